@@ -9,8 +9,12 @@ import (
 )
 
 const (
-	ConfigName     = "config.yaml"
-	configFilePath = ""
+	ConfigName      = "config.yaml"
+	CalibrationName = "calibration.json"
+	DeviceStateName = "devices.json"
+	DoseHistoryName = "doses.json"
+	RuntimeName     = "runtime.json"
+	configFilePath  = ""
 )
 
 // GetPath returns the path of the file.
@@ -38,3 +42,39 @@ func ReadConfigFile() ([]byte, error) {
 	}
 	return fileByte, nil
 }
+
+// ReadCalibrationFile - reads the calibration records saved next to the config file. A nil
+// slice is returned when nothing was saved yet.
+func ReadCalibrationFile() ([]byte, error) {
+	return ReadDataFile(CalibrationName)
+}
+
+// WriteCalibrationFile - replaces the calibration records saved next to the config file.
+func WriteCalibrationFile(data []byte) error {
+	return WriteDataFile(CalibrationName, data)
+}
+
+// ReadDataFile - reads a file the controller saved next to the config file. A nil slice is
+// returned when nothing was saved yet.
+func ReadDataFile(name string) ([]byte, error) {
+	fullpath := strings.Join([]string{getPath(), name}, "/")
+	data, err := ioutil.ReadFile(fullpath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// WriteDataFile - replaces a file saved next to the config file.
+func WriteDataFile(name string, data []byte) error {
+	fullpath := strings.Join([]string{getPath(), name}, "/")
+	// write to a temporary file first so a power cut cannot leave half a file behind.
+	tmp := fullpath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fullpath)
+}
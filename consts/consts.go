@@ -9,13 +9,22 @@ type I2CSensor string
 
 type ADS1115Device string
 
+type HardwareBackend string
+
 const (
-	Temperature BucketFilter = "temperature"
-	Humidity    BucketFilter = "humidity"
-	PH          BucketFilter = "ph"
-	EC          BucketFilter = "ec"
-	WaterLevel  BucketFilter = "waterlevel"
-	All         BucketFilter = ""
+	Temperature      BucketFilter = "temperature"
+	Humidity         BucketFilter = "humidity"
+	PH               BucketFilter = "ph"
+	EC               BucketFilter = "ec"
+	CO2              BucketFilter = "co2"
+	Light            BucketFilter = "light"
+	WaterLevel       BucketFilter = "waterlevel"
+	WaterVolume      BucketFilter = "watervolume"
+	WaterTemperature BucketFilter = "watertemperature"
+	VPD              BucketFilter = "vpd"
+	DewPoint         BucketFilter = "dewpoint"
+	AbsoluteHumidity BucketFilter = "absolutehumidity"
+	All              BucketFilter = ""
 
 	Sensor      BucketName = "sensor"
 	User        BucketName = "user"
@@ -26,13 +35,32 @@ const (
 	CoolingFan      OutputDevice = "coolingFan"
 	CirculationPump OutputDevice = "circulationpump"
 	GrowLight       OutputDevice = "growlight"
+	PHUpPump        OutputDevice = "phuppump"
+	PHDownPump      OutputDevice = "phdownpump"
+	TopUpValve      OutputDevice = "topupvalve"
+	AirPump         OutputDevice = "airpump"
+	NutrientAPump   OutputDevice = "nutrientapump"
+	NutrientBPump   OutputDevice = "nutrientbpump"
+	Humidifier      OutputDevice = "humidifier"
+	Dehumidifier    OutputDevice = "dehumidifier"
+	Heater          OutputDevice = "heater"
+	CO2Valve        OutputDevice = "co2valve"
 
 	WaterLevelSensor AnalogSensor = "waterlevel"
 	PHSensor         AnalogSensor = "ph"
+	ECSensor         AnalogSensor = "ec"
+
+	WaterTemperatureSensor AnalogSensor = "watertemperature"
 
 	Sth3xTemperature I2CSensor = "sth3xtemperature"
 	Sth3xHumidity    I2CSensor = "sth3xhumidity"
+	SHT3x            I2CSensor = "sht3x"
+	SCD30            I2CSensor = "scd30"
+	BH1750           I2CSensor = "bh1750"
 
 	ADS1115Device1 ADS1115Device = "ads1115_1"
 	ADS1115Device2 ADS1115Device = "ads1115_2"
+
+	RaspberryPi HardwareBackend = "raspberrypi"
+	Simulated   HardwareBackend = "simulated"
 )
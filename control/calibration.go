@@ -0,0 +1,67 @@
+package control
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/only1isus/majorProj/config"
+)
+
+// calibrationMu guards the calibration file, every sensor saves its record to the same file.
+var calibrationMu sync.Mutex
+
+// loadCalibration reads the record saved for the sensor into v. It returns false when no
+// record was saved for the sensor.
+func loadCalibration(sensor string, v interface{}) (bool, error) {
+	calibrationMu.Lock()
+	defer calibrationMu.Unlock()
+
+	records, err := readCalibrationRecords()
+	if err != nil {
+		return false, err
+	}
+	record, ok := records[sensor]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(record, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// saveCalibration replaces the record saved for the sensor with v.
+func saveCalibration(sensor string, v interface{}) error {
+	calibrationMu.Lock()
+	defer calibrationMu.Unlock()
+
+	records, err := readCalibrationRecords()
+	if err != nil {
+		return err
+	}
+	record, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	records[sensor] = record
+	out, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return config.WriteCalibrationFile(out)
+}
+
+func readCalibrationRecords() (map[string]json.RawMessage, error) {
+	records := map[string]json.RawMessage{}
+	data, err := config.ReadCalibrationFile()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return records, nil
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,35 @@
+package control
+
+import (
+	"os"
+	"testing"
+)
+
+// inTempDir runs the rest of the test in a temporary directory, the data files are saved there.
+func inTempDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestCalibrationRecords(t *testing.T) {
+	inTempDir(t)
+	var record ECCalibrationRecord
+	if ok, err := loadCalibration("ec", &record); ok || err != nil {
+		t.Fatalf("expected no record before one is saved, got %v %v", ok, err)
+	}
+	if err := saveCalibration("ec", ECCalibrationRecord{Time: 1, Slope: 2, Offset: 0.1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveCalibration("waterlevel", WaterLevelCalibration{Time: 1, Empty: 0.2, Full: 3.8}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := loadCalibration("ec", &record); !ok || err != nil || record.Slope != 2 || record.Offset != 0.1 {
+		t.Errorf("expected the ec record kept beside the water level record, got %+v %v %v", record, ok, err)
+	}
+}
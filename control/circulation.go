@@ -0,0 +1,261 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+// CycleMode is how the circulation pump feeds the beds.
+type CycleMode string
+
+const (
+	// NFT keeps a thin film of nutrients running through the channels, the pump never stops.
+	NFT CycleMode = "nft"
+	// EbbAndFlow floods the beds then drains them back to the reservoir on a timer.
+	EbbAndFlow CycleMode = "ebbAndFlow"
+)
+
+// cycleInterval is how often the float switch and the state of the pump are checked during a
+// flood or a drain.
+const cycleInterval = time.Second
+
+// CirculationSetting is the circulation section of the config file. Times are in minutes.
+type CirculationSetting struct {
+	Mode        CycleMode    `yaml:"mode"`
+	Flood       int64        `yaml:"flood"`       // longest the pump fills the beds.
+	Drain       int64        `yaml:"drain"`       // the pump runs in reverse, 0 when the beds drain on their own.
+	Every       int64        `yaml:"every"`       // between the start of two floods.
+	FloatSwitch *FloatSwitch `yaml:"floatSwitch"` // ends a flood once the beds are full.
+}
+
+// FloatSwitch is a switch in the beds wired to an input pin with its pull up on.
+type FloatSwitch struct {
+	Pin       uint8 `yaml:"pin"`
+	ActiveLow bool  `yaml:"activeLow"` // the switch pulls the pin low when the beds are full.
+}
+
+type circulationConfig struct {
+	Circulation *CirculationSetting `yaml:"circulation"`
+}
+
+// Circulation runs the circulation pump in the mode of the circulation setting.
+type Circulation struct {
+	setting CirculationSetting
+	pump    *OutputDevice
+	minute  time.Duration // the unit of the setting, shorter in the tests.
+	poll    time.Duration
+}
+
+// NewCirculation reads the circulation section of the config file. It returns nil when the
+// section is not set, the circulation pump then follows its schedules like any other device.
+func NewCirculation(devices *DeviceManager) (*Circulation, error) {
+	var setting circulationConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	if setting.Circulation == nil {
+		return nil, nil
+	}
+	return newCirculation(*setting.Circulation, devices)
+}
+
+func newCirculation(setting CirculationSetting, devices *DeviceManager) (*Circulation, error) {
+	pump, err := devices.Device(consts.CirculationPump)
+	if err != nil {
+		return nil, err
+	}
+	if !pump.Automatic {
+		return nil, fmt.Errorf("%s needs to be automatic to run the %s cycle", pump.Name, setting.Mode)
+	}
+	switch setting.Mode {
+	case NFT:
+	case EbbAndFlow:
+		if setting.Flood <= 0 || setting.Drain < 0 {
+			return nil, fmt.Errorf("the ebbAndFlow cycle needs a flood greater than 0 and a drain of 0 or more")
+		}
+		if setting.Every < setting.Flood+setting.Drain {
+			return nil, fmt.Errorf("the ebbAndFlow cycle needs an every of at least the flood and drain times")
+		}
+		if setting.Drain > 0 && pump.usesRelay() {
+			return nil, fmt.Errorf("%s is switched by a relay and cannot drain the beds", pump.Name)
+		}
+		if s := setting.FloatSwitch; s != nil {
+			for _, device := range devices.Devices() {
+				for _, pin := range device.usedPins() {
+					if pin == s.Pin {
+						return nil, fmt.Errorf("the float switch and %s both use pin %d", device.Name, pin)
+					}
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown circulation mode %q, use %s or %s", setting.Mode, NFT, EbbAndFlow)
+	}
+	return &Circulation{setting: setting, pump: pump, minute: time.Minute, poll: cycleInterval}, nil
+}
+
+// Run runs the cycle until ctx is done. Every completed flood and drain is sent over the entry
+// channel, so are the pump failures.
+func (c *Circulation) Run(ctx context.Context, entry chan *types.LogEntry) error {
+	if c.setting.Mode == NFT {
+		return c.runNFT(ctx, entry)
+	}
+	every := c.minute * time.Duration(c.setting.Every)
+	for {
+		start := time.Now()
+		message, err := c.cycle(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// the beds are left to drain until the next cycle.
+			c.pump.With(ReasonSchedule).Off()
+			entry <- &types.LogEntry{
+				Message: fmt.Sprintf("Something went wrong running the flood and drain cycle %v", err),
+				Success: false,
+				Time:    time.Now().Unix(),
+				Type:    string(c.pump.Name),
+			}
+		} else {
+			entry <- &types.LogEntry{
+				Message: message,
+				Success: true,
+				Time:    time.Now().Unix(),
+				Type:    string(c.pump.Name),
+			}
+		}
+		if err := sleep(ctx, time.Until(start.Add(every))); err != nil {
+			return err
+		}
+	}
+}
+
+// cycle floods the beds until the flood time is over or the float switch reports them full,
+// then runs the pump in reverse for the drain time. It returns the description of the cycle. The
+// pump is left off while a rule still met holds it off, as the lowwater rule does, the flood is
+// skipped and a flood or drain under way ends.
+func (c *Circulation) cycle(ctx context.Context) (string, error) {
+	pump := c.pump.With(ReasonSchedule)
+	if c.heldByRule() {
+		return fmt.Sprintf("Skipped the flood, a rule holds %s off.", pump.Name), nil
+	}
+	if err := pump.On(); err != nil {
+		return "", err
+	}
+	start := time.Now()
+	deadline := start.Add(c.minute * time.Duration(c.setting.Flood))
+	full := false
+	for !full && time.Now().Before(deadline) {
+		wait := time.Until(deadline)
+		if wait > c.poll {
+			wait = c.poll
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return "", err
+		}
+		if c.heldByRule() {
+			return fmt.Sprintf("Stopped flooding the beds after %v, a rule turned %s off.", time.Since(start).Round(time.Second), pump.Name), nil
+		}
+		if c.setting.FloatSwitch != nil {
+			var err error
+			if full, err = c.full(); err != nil {
+				return "", err
+			}
+		}
+	}
+	flooded := time.Since(start).Round(time.Second)
+	ended := ""
+	if full {
+		ended = " until the float switch reported the beds full"
+	}
+
+	if c.setting.Drain == 0 {
+		if err := pump.Off(); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Flooded the beds for %v%s, they drain on their own.", flooded, ended), nil
+	}
+	// the pump turns the other way without stopping, a break would count as off time.
+	if err := pump.Reverse(); err != nil {
+		return "", err
+	}
+	drainStart := time.Now()
+	deadline = drainStart.Add(c.minute * time.Duration(c.setting.Drain))
+	for time.Now().Before(deadline) {
+		wait := time.Until(deadline)
+		if wait > c.poll {
+			wait = c.poll
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return "", err
+		}
+		if c.heldByRule() {
+			return fmt.Sprintf("Flooded the beds for %v%s and stopped draining them after %v, a rule turned %s off.", flooded, ended, time.Since(drainStart).Round(time.Second), pump.Name), nil
+		}
+	}
+	if err := pump.Off(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Flooded the beds for %v%s and drained them for %v minutes.", flooded, ended, c.setting.Drain), nil
+}
+
+// heldByRule reports whether the pump is off and held off by a rule still met.
+func (c *Circulation) heldByRule() bool {
+	state, _ := tracker.state(c.pump.Name, time.Now())
+	return !state.On && holds.held(c.pump.Name)
+}
+
+// full reads the float switch.
+func (c *Circulation) full() (bool, error) {
+	gpio, err := CurrentHardware().OpenGPIO()
+	if err != nil {
+		return false, err
+	}
+	defer gpio.Close()
+
+	pin := gpio.Pin(c.setting.FloatSwitch.Pin)
+	pin.Input()
+	pin.PullUp()
+	return pin.Read() != c.setting.FloatSwitch.ActiveLow, nil
+}
+
+// runNFT keeps the pump running. The pump is turned back on every minute after it was stopped,
+// unless a rule still met holds it off.
+func (c *Circulation) runNFT(ctx context.Context, entry chan *types.LogEntry) error {
+	pump := c.pump.With(ReasonSchedule)
+	failing, started := false, false
+	for {
+		state, _ := tracker.state(pump.Name, time.Now())
+		if !started || !state.On && !holds.held(pump.Name) {
+			started = true
+			if err := pump.On(); err != nil {
+				// only the first failure is logged until the pump runs again.
+				if !failing {
+					entry <- &types.LogEntry{
+						Message: fmt.Sprintf("Something went wrong turning %s on for the nft cycle %v", pump.Name, err),
+						Success: false,
+						Time:    time.Now().Unix(),
+						Type:    string(pump.Name),
+					}
+				}
+				failing = true
+			} else {
+				failing = false
+			}
+		}
+		if err := sleep(ctx, time.Minute); err != nil {
+			return err
+		}
+	}
+}
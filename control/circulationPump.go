@@ -7,7 +7,7 @@ import (
 type CirculationPump OutputDevice
 
 func NewCirculationPump() (CirculationPump, error) {
-	cp, err := NewOutputDevice(consts.CoolingFan)
+	cp, err := NewOutputDevice(consts.CirculationPump)
 	if err != nil {
 		return CirculationPump{}, err
 	}
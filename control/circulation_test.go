@@ -0,0 +1,162 @@
+package control
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+func TestCirculationSetting(t *testing.T) {
+	devices, err := newDeviceManager([]OutputDevice{
+		{Name: "circulationpump", Pins: DriverPins{EN: 25, IN1: 7, IN2: 8}, Rate: 1, Automatic: true},
+		{Name: "airpump", Pin: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newCirculation(CirculationSetting{Mode: EbbAndFlow, Flood: 15, Drain: 10, Every: 120}, devices); err != nil {
+		t.Errorf("expected a valid ebbAndFlow cycle, got %v", err)
+	}
+	for _, setting := range []CirculationSetting{
+		{Mode: "dwc"},
+		{Mode: EbbAndFlow, Drain: 10, Every: 120},
+		{Mode: EbbAndFlow, Flood: 15, Drain: 10, Every: 20},
+		{Mode: EbbAndFlow, Flood: 15, Every: 120, FloatSwitch: &FloatSwitch{Pin: 4}},
+	} {
+		if _, err := newCirculation(setting, devices); err == nil {
+			t.Errorf("expected an error for %+v", setting)
+		}
+	}
+
+	relay, err := newDeviceManager([]OutputDevice{{Name: "circulationpump", Pin: 25, Automatic: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newCirculation(CirculationSetting{Mode: EbbAndFlow, Flood: 15, Drain: 10, Every: 120}, relay); err == nil {
+		t.Error("expected an error draining through a relay switched pump")
+	}
+	if _, err := newCirculation(CirculationSetting{Mode: NFT}, relay); err != nil {
+		t.Errorf("expected an nft cycle on a relay switched pump, got %v", err)
+	}
+}
+
+func TestFloodAndDrain(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	devices, err := newDeviceManager([]OutputDevice{
+		{Name: "circulationpump", Pins: DriverPins{EN: 25, IN1: 7, IN2: 8}, Rate: 1, Automatic: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newCirculation(CirculationSetting{Mode: EbbAndFlow, Flood: 100, Drain: 20, Every: 200, FloatSwitch: &FloatSwitch{Pin: 10, ActiveLow: true}}, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.minute, c.poll = 10*time.Millisecond, time.Millisecond
+
+	type result struct {
+		message string
+		err     error
+	}
+	done := make(chan result)
+	go func() {
+		message, err := c.cycle(context.Background())
+		done <- result{message, err}
+	}()
+
+	// the float switch closes once the beds are full, well before the flood time.
+	time.Sleep(50 * time.Millisecond)
+	if state := hw.PinState(7); !state.High {
+		t.Fatal("expected the pump flooding the beds")
+	}
+	hw.SetInput(10, false)
+	deadline := time.After(time.Second)
+	for !hw.PinState(8).High {
+		select {
+		case <-deadline:
+			t.Fatal("expected the pump to drain the beds in reverse")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if hw.PinState(7).High || hw.PinState(25).DutyCycle != 1 {
+		t.Errorf("expected IN1 low and the pump at its rate while draining, got %+v and %+v", hw.PinState(7), hw.PinState(25))
+	}
+
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if !strings.Contains(r.message, "until the float switch reported the beds full") || !strings.Contains(r.message, "drained them for 20 minutes") {
+		t.Errorf("unexpected cycle %q", r.message)
+	}
+	if hw.PinState(25).DutyCycle != 0 || hw.PinState(7).High || hw.PinState(8).High {
+		t.Error("expected the pump off after the cycle")
+	}
+}
+
+func TestFloodStoppedByRule(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	saved := tracker
+	tracker = &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	savedHolds := holds
+	holds = &ruleHolds{rules: map[consts.OutputDevice]map[string]bool{}}
+	defer func() { tracker, holds = saved, savedHolds }()
+	devices, err := newDeviceManager([]OutputDevice{
+		{Name: "circulationpump", Pins: DriverPins{EN: 25, IN1: 7, IN2: 8}, Rate: 1, Automatic: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newCirculation(CirculationSetting{Mode: EbbAndFlow, Flood: 100, Drain: 20, Every: 200}, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.minute, c.poll = 10*time.Millisecond, time.Millisecond
+	pump, _ := devices.Device(consts.CirculationPump)
+
+	// the lowwater rule turned the pump off before the flood.
+	holds.hold(pump.Name, "lowwater")
+	if err := pump.With(ReasonRule).Off(); err != nil {
+		t.Fatal(err)
+	}
+	message, err := c.cycle(context.Background())
+	if err != nil || !strings.Contains(message, "Skipped the flood") || hw.PinState(7).High {
+		t.Fatalf("expected the flood skipped, got %q %v", message, err)
+	}
+
+	// once the rule is no longer met the pump is back with the cycle. The rule then turns it off
+	// again during the flood, the beds are not drained.
+	holds.release("lowwater")
+	done := make(chan string)
+	go func() {
+		message, _ := c.cycle(context.Background())
+		done <- message
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if !hw.PinState(7).High {
+		t.Fatal("expected the pump flooding the beds")
+	}
+	holds.hold(pump.Name, "lowwater")
+	if err := pump.With(ReasonRule).Off(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-done:
+		if !strings.Contains(message, "Stopped flooding the beds") {
+			t.Errorf("unexpected cycle %q", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the flood to end once the rule turned the pump off")
+	}
+	if hw.PinState(7).High || hw.PinState(8).High || hw.PinState(25).DutyCycle != 0 {
+		t.Error("expected the pump left off")
+	}
+}
@@ -0,0 +1,106 @@
+package control
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+)
+
+// climateMaxAge is how long a reading of an SHT3x is shared by the sensors of the chip, so the
+// temperature, the humidity and the metrics derived from them come from one read of the bus.
+const climateMaxAge = 5 * time.Second
+
+// ClimateSensor is an SHT3x read for the temperature, the humidity and the metrics derived from
+// both.
+type ClimateSensor I2CSensor
+
+// ClimateReading is one measurement of an SHT3x with the metrics derived from it.
+type ClimateReading struct {
+	Temperature      float64 // c
+	Humidity         float64 // %
+	VPD              float64 // vapour pressure deficit, kPa
+	DewPoint         float64 // c
+	AbsoluteHumidity float64 // g/m3
+	Time             time.Time
+}
+
+// climateKey is a chip on a bus of a hardware backend.
+type climateKey struct {
+	hardware Hardware
+	bus      int
+	address  uint8
+}
+
+var (
+	climateMu sync.Mutex
+	climates  = map[climateKey]*ClimateReading{}
+)
+
+// NewClimateSensor returns the sht3x from the i2cSensors setting.
+func NewClimateSensor() (*ClimateSensor, error) {
+	climateSensor, err := NewI2CSensor(consts.SHT3x)
+	if err != nil {
+		return nil, err
+	}
+	cs := ClimateSensor(*climateSensor)
+	return &cs, nil
+}
+
+// Get returns the last reading of the chip when it is less than 5 seconds old, a new one
+// otherwise.
+func (c *ClimateSensor) Get() (*ClimateReading, error) {
+	return readClimate(I2CSensor(*c), time.Now())
+}
+
+func readClimate(s I2CSensor, now time.Time) (*ClimateReading, error) {
+	hw := CurrentHardware()
+	key := climateKey{hardware: hw, bus: s.Bus, address: s.Address}
+	// the lock is held during the read so the sensors of a chip never read it together.
+	climateMu.Lock()
+	defer climateMu.Unlock()
+	if last, ok := climates[key]; ok && now.Sub(last.Time) < climateMaxAge && !now.Before(last.Time) {
+		reading := *last
+		return &reading, nil
+	}
+
+	i2cconn, err := hw.OpenI2C(s.Address, s.Bus)
+	if err != nil {
+		return nil, err
+	}
+	defer i2cconn.Close()
+	temperature, humidity, err := readSHT3x(i2cconn)
+	if err != nil {
+		return nil, err
+	}
+	reading, err := newClimateReading(temperature, humidity, now)
+	if err != nil {
+		return nil, err
+	}
+	climates[key] = reading
+	shared := *reading
+	return &shared, nil
+}
+
+// newClimateReading derives the vapour pressure deficit, the dew point and the absolute
+// humidity from the temperature (c) and the relative humidity (%) using the Magnus formula.
+func newClimateReading(temperature, humidity float64, at time.Time) (*ClimateReading, error) {
+	if humidity <= 0 || humidity > 100 {
+		return nil, fmt.Errorf("the humidity of %v%% is out of range", humidity)
+	}
+	const a, b = 17.62, 243.12
+	// saturation vapour pressure in hPa.
+	saturation := 6.112 * math.Exp(a*temperature/(b+temperature))
+	vapour := saturation * humidity / 100
+	gamma := math.Log(humidity/100) + a*temperature/(b+temperature)
+	return &ClimateReading{
+		Temperature:      ToFixed(temperature, 1),
+		Humidity:         ToFixed(humidity, 1),
+		VPD:              ToFixed((saturation-vapour)/10, 2),
+		DewPoint:         ToFixed(b*gamma/(a-gamma), 1),
+		AbsoluteHumidity: ToFixed(216.7*vapour/(273.15+temperature), 1),
+		Time:             at,
+	}, nil
+}
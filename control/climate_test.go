@@ -0,0 +1,78 @@
+package control
+
+import (
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+)
+
+// countingSHT3x counts the measurements taken.
+type countingSHT3x struct {
+	*SimulatedSHT3x
+	reads int
+}
+
+func (c *countingSHT3x) ReadBytes(buf []byte) (int, error) {
+	c.reads++
+	return c.SimulatedSHT3x.ReadBytes(buf)
+}
+
+func TestClimateReading(t *testing.T) {
+	reading, err := newClimateReading(25, 50, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.VPD != 1.58 || reading.DewPoint != 13.9 || reading.AbsoluteHumidity != 11.5 {
+		t.Errorf("expected a vpd of 1.58, a dew point of 13.9 and 11.5 g/m3 at 25c and 50%%, got %+v", reading)
+	}
+	if reading, err := newClimateReading(20, 100, time.Now()); err != nil || reading.VPD != 0 || reading.DewPoint != 20 {
+		t.Errorf("expected no deficit and the dew point at the temperature when saturated, got %+v %v", reading, err)
+	}
+	if _, err := newClimateReading(20, 0, time.Now()); err == nil {
+		t.Error("expected an error for a humidity of 0")
+	}
+}
+
+func TestClimateSensor(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	chip := &countingSHT3x{SimulatedSHT3x: NewSimulatedSHT3x(25, 50)}
+	hw.Attach(0x45, 1, chip)
+
+	registry, err := newRegistry(nil, []I2CSensor{{Name: "sht3x", Bus: 1, Address: 0x45, Every: 5}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[consts.BucketFilter]float64{
+		consts.Temperature:      25,
+		consts.Humidity:         50,
+		consts.VPD:              1.58,
+		consts.DewPoint:         13.9,
+		consts.AbsoluteHumidity: 11.5,
+	}
+	sensors := registry.Sensors()
+	if len(sensors) != len(want) {
+		t.Fatalf("expected %d sensors, got %d", len(want), len(sensors))
+	}
+	for _, s := range sensors {
+		value, err := s.Read()
+		if err != nil || value != want[s.Kind()] {
+			t.Errorf("expected %s to read %v, got %v %v", s.Name(), want[s.Kind()], value, err)
+		}
+	}
+	if chip.reads != 1 {
+		t.Errorf("expected the sensors to share one reading of the chip, got %d", chip.reads)
+	}
+	if _, ok := registry.Lookup(consts.Temperature).(*TemperatureSensor); !ok {
+		t.Error("expected the temperature sensor behind the temperature of the sht3x")
+	}
+
+	// a reading older than climateMaxAge is taken again.
+	chip.Set(30, 40)
+	reading, err := readClimate(I2CSensor{Bus: 1, Address: 0x45}, time.Now().Add(climateMaxAge))
+	if err != nil || reading.Temperature != 30 || chip.reads != 2 {
+		t.Errorf("expected a new reading of 30c, got %+v %v after %d reads", reading, err, chip.reads)
+	}
+}
@@ -0,0 +1,173 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+// CO2Control is the co2Control section of the config file.
+type CO2Control struct {
+	Setpoint   float64 `yaml:"setpoint"`   // ppm, the co2valve opens below it.
+	Hysteresis float64 `yaml:"hysteresis"` // ppm above the setpoint the valve closes at.
+	Every      int64   `yaml:"every"`      // seconds between updates.
+}
+
+type co2ControlConfig struct {
+	CO2Control *CO2Control `yaml:"co2Control"`
+}
+
+// CO2Enricher keeps the co2 above the setpoint of the co2Control setting by opening the co2valve.
+// The valve stays closed while the cooling fan runs, the co2 would be blown out. The setpoint
+// follows the setpoint profiles.
+type CO2Enricher struct {
+	setting  CO2Control
+	profiles *SetpointProfiles // nil without profiles.
+	sensor   Sensor
+	valve    *OutputDevice
+	fan      *OutputDevice // nil without a cooling fan.
+
+	enriching bool
+	blocked   bool // the valve was kept closed for the fan.
+	started   time.Time
+	from      float64 // the co2 the enrichment started at.
+}
+
+// NewCO2Enricher reads the co2Control section of the config file. It returns nil when the
+// section is not set.
+func NewCO2Enricher(sensors *Registry, devices *DeviceManager) (*CO2Enricher, error) {
+	var setting co2ControlConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	if setting.CO2Control == nil {
+		return nil, nil
+	}
+	profiles, err := NewSetpointProfiles()
+	if err != nil {
+		return nil, err
+	}
+	return newCO2Enricher(*setting.CO2Control, profiles, sensors, devices)
+}
+
+func newCO2Enricher(setting CO2Control, profiles *SetpointProfiles, sensors *Registry, devices *DeviceManager) (*CO2Enricher, error) {
+	for _, sp := range profiles.each(climateSetpoints{co2: setting.Setpoint}) {
+		if sp.co2 < 0 {
+			return nil, fmt.Errorf("co2Control cannot have a negative setpoint")
+		}
+	}
+	if setting.Hysteresis <= 0 || setting.Every <= 0 {
+		return nil, fmt.Errorf("co2Control needs a hysteresis and an every greater than 0")
+	}
+	sensor, ok := sensors.find(string(consts.CO2))
+	if !ok {
+		return nil, fmt.Errorf("co2Control needs a co2 sensor")
+	}
+	valve, err := devices.Device(consts.CO2Valve)
+	if err != nil {
+		return nil, err
+	}
+	if !valve.Automatic {
+		return nil, fmt.Errorf("%s needs to be automatic to be run by the co2 control", valve.Name)
+	}
+	c := &CO2Enricher{setting: setting, profiles: profiles, sensor: sensor, valve: valve}
+	if fan, err := devices.Device(consts.CoolingFan); err == nil {
+		c.fan = fan
+	}
+	return c, nil
+}
+
+// Run updates the valve every few seconds, as set by every, until ctx is done. The start and
+// the end of every enrichment are sent over the entry channel.
+func (c *CO2Enricher) Run(ctx context.Context, entry chan *types.LogEntry) error {
+	ticker := time.NewTicker(time.Second * time.Duration(c.setting.Every))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		for _, logEntry := range c.step(time.Now()) {
+			select {
+			case entry <- logEntry:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// step reads the co2 and opens or closes the valve.
+func (c *CO2Enricher) step(now time.Time) []*types.LogEntry {
+	// the safety or a rule may have closed the valve since the last step.
+	if state, _ := tracker.state(c.valve.Name, now); c.enriching && !state.On {
+		c.enriching = false
+	}
+	value, err := c.sensor.Read()
+	if err != nil {
+		entries := []*types.LogEntry{c.entry(fmt.Sprintf("Something went wrong reading the co2 %v", err), false, now)}
+		// the valve is not left open blind.
+		if c.enriching {
+			entries = append(entries, c.stop(now, "as the co2 cannot be read"))
+		}
+		return entries
+	}
+	setpoint := c.profiles.at(now, climateSetpoints{co2: c.setting.Setpoint}).co2
+
+	fanOn := false
+	if c.fan != nil {
+		state, _ := tracker.state(c.fan.Name, now)
+		fanOn = state.On
+	}
+	switch {
+	case c.enriching && value >= setpoint+c.setting.Hysteresis:
+		return []*types.LogEntry{c.stop(now, fmt.Sprintf("at %vppm", value))}
+	case c.enriching && fanOn:
+		c.blocked = true
+		return []*types.LogEntry{c.stop(now, fmt.Sprintf("at %vppm while %s runs", value, c.fan.Name))}
+	case c.enriching || value >= setpoint:
+		c.blocked = false
+		return nil
+	case fanOn:
+		if c.blocked {
+			return nil
+		}
+		c.blocked = true
+		return []*types.LogEntry{c.entry(fmt.Sprintf("CO2 is %vppm, below the setpoint of %vppm. Kept %s closed while %s runs.", value, setpoint, c.valve.Name, c.fan.Name), true, now)}
+	}
+	c.blocked = false
+	if err := c.valve.With(ReasonControl).On(); err != nil {
+		return []*types.LogEntry{c.entry(fmt.Sprintf("Something went wrong opening %s %v", c.valve.Name, err), false, now)}
+	}
+	c.enriching, c.started, c.from = true, now, value
+	return []*types.LogEntry{c.entry(fmt.Sprintf("CO2 is %vppm, below the setpoint of %vppm. Opened %s.", value, setpoint, c.valve.Name), true, now)}
+}
+
+// stop closes the valve and returns the enrichment.
+func (c *CO2Enricher) stop(now time.Time, why string) *types.LogEntry {
+	if err := c.valve.With(ReasonControl).Off(); err != nil {
+		return c.entry(fmt.Sprintf("Something went wrong closing %s %v", c.valve.Name, err), false, now)
+	}
+	c.enriching = false
+	return c.entry(fmt.Sprintf("Closed %s %s. It was open for %v from %vppm.", c.valve.Name, why, now.Sub(c.started).Round(time.Second), c.from), true, now)
+}
+
+func (c *CO2Enricher) entry(message string, success bool, now time.Time) *types.LogEntry {
+	return &types.LogEntry{
+		Message: message,
+		Success: success,
+		Time:    now.Unix(),
+		Type:    string(consts.CO2),
+	}
+}
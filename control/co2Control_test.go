@@ -0,0 +1,94 @@
+package control
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+func TestCO2Enricher(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	saved := tracker
+	tracker = &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	defer func() { tracker = saved }()
+
+	co2 := 900.0
+	sensors := &Registry{}
+	sensors.add(&sensor{name: "co2", kind: consts.CO2, read: func() (*float64, error) { return &co2, nil }})
+	devices, err := newDeviceManager([]OutputDevice{
+		{Name: consts.CO2Valve, Pin: 5, Rate: 1, Automatic: true},
+		{Name: consts.CoolingFan, Pins: DriverPins{EN: 22, IN1: 27, IN2: 17}, Rate: 1, Automatic: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the night profile does not enrich.
+	day, night := 1000.0, 0.0
+	profiles := &SetpointProfiles{SwitchBy: SwitchByClock, DayFrom: "06:00", NightFrom: "22:00", Day: SetpointProfile{CO2: &day}, Night: SetpointProfile{CO2: &night}}
+	c, err := newCO2Enricher(CO2Control{Setpoint: 800, Hysteresis: 100, Every: 30}, profiles, sensors, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fan, _ := devices.Device(consts.CoolingFan)
+	noon := time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)
+
+	if entries := c.step(noon.Add(12 * time.Hour)); len(entries) != 0 || hw.PinState(5).High {
+		t.Fatalf("expected the valve closed at night, got %v", entries)
+	}
+	if entries := c.step(noon); len(entries) != 1 || !strings.Contains(entries[0].Message, "below the setpoint of 1000ppm") || !hw.PinState(5).High {
+		t.Fatalf("expected the valve open below the day setpoint, got %v", entries)
+	}
+	// inside the hysteresis the valve stays open.
+	co2 = 1050
+	if entries := c.step(noon.Add(time.Minute)); len(entries) != 0 || !hw.PinState(5).High {
+		t.Errorf("expected the valve open inside the hysteresis, got %v", entries)
+	}
+	co2 = 1100
+	entries := c.step(noon.Add(5 * time.Minute))
+	if len(entries) != 1 || !strings.Contains(entries[0].Message, "It was open for 5m0s from 900ppm") || hw.PinState(5).High {
+		t.Fatalf("expected the valve closed above the hysteresis, got %v", entries)
+	}
+
+	// the fan blows the co2 out, the valve waits for it.
+	if err := fan.With(ReasonControl).On(); err != nil {
+		t.Fatal(err)
+	}
+	co2 = 900
+	entries = c.step(noon.Add(6 * time.Minute))
+	if len(entries) != 1 || !strings.Contains(entries[0].Message, "Kept co2valve closed while coolingFan runs") || hw.PinState(5).High {
+		t.Fatalf("expected the valve kept closed while the fan runs, got %v", entries)
+	}
+	if entries := c.step(noon.Add(7 * time.Minute)); len(entries) != 0 {
+		t.Errorf("expected the fan logged once, got %v", entries)
+	}
+	if err := fan.With(ReasonControl).Off(); err != nil {
+		t.Fatal(err)
+	}
+	if entries := c.step(noon.Add(8 * time.Minute)); len(entries) != 1 || !hw.PinState(5).High {
+		t.Errorf("expected the valve open once the fan stopped, got %v", entries)
+	}
+}
+
+func TestCO2EnricherSetting(t *testing.T) {
+	sensors := &Registry{}
+	sensors.add(&sensor{name: "co2", kind: consts.CO2})
+	devices, err := newDeviceManager([]OutputDevice{{Name: consts.CO2Valve, Pin: 5, Rate: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, setting := range []CO2Control{
+		{Setpoint: -1, Hysteresis: 100, Every: 30},
+		{Setpoint: 1000, Every: 30},
+		// the valve is not automatic.
+		{Setpoint: 1000, Hysteresis: 100, Every: 30},
+	} {
+		if _, err := newCO2Enricher(setting, nil, sensors, devices); err == nil {
+			t.Errorf("expected an error for %+v", setting)
+		}
+	}
+}
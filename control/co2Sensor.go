@@ -0,0 +1,141 @@
+package control
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+)
+
+// CO2Sensor is a Sensirion SCD30 NDIR CO2 sensor. Readings are in ppm.
+type CO2Sensor I2CSensor
+
+// SCD30 commands, see the SCD30 interface description.
+var (
+	scd30StartMeasurement = []byte{0x00, 0x10, 0x00, 0x00, 0x81} // continuous, no pressure compensation.
+	scd30DataReady        = []byte{0x02, 0x02}
+	scd30ReadMeasurement  = []byte{0x03, 0x00}
+)
+
+// NewCO2Sensor returns the scd30 from the i2cSensors setting and starts its continuous measurement.
+func NewCO2Sensor() (*CO2Sensor, error) {
+	co2Sensor, err := NewI2CSensor(consts.SCD30)
+	if err != nil {
+		return nil, err
+	}
+	return newCO2Sensor(*co2Sensor)
+}
+
+func newCO2Sensor(sensor I2CSensor) (*CO2Sensor, error) {
+	i2cconn, err := CurrentHardware().OpenI2C(sensor.Address, sensor.Bus)
+	if err != nil {
+		return nil, err
+	}
+	defer i2cconn.Close()
+	if _, err := i2cconn.WriteBytes(scd30StartMeasurement); err != nil {
+		return nil, err
+	}
+	cs := CO2Sensor(sensor)
+	return &cs, nil
+}
+
+// Get returns the last CO2 concentration measured.
+func (c *CO2Sensor) Get() (*float64, error) {
+	i2cconn, err := CurrentHardware().OpenI2C(c.Address, c.Bus)
+	if err != nil {
+		return nil, err
+	}
+	defer i2cconn.Close()
+
+	if _, err := i2cconn.WriteBytes(scd30DataReady); err != nil {
+		return nil, err
+	}
+	time.Sleep(3 * time.Millisecond)
+	ready := make([]byte, 3)
+	if _, err := i2cconn.ReadBytes(ready); err != nil {
+		return nil, err
+	}
+	if sht3xCRC(ready[0:2]) != ready[2] {
+		return nil, fmt.Errorf("scd30 checksum mismatch")
+	}
+	if ready[1] != 1 {
+		return nil, fmt.Errorf("the scd30 has no measurement ready")
+	}
+
+	if _, err := i2cconn.WriteBytes(scd30ReadMeasurement); err != nil {
+		return nil, err
+	}
+	time.Sleep(3 * time.Millisecond)
+	buf := make([]byte, 18)
+	if _, err := i2cconn.ReadBytes(buf); err != nil {
+		return nil, err
+	}
+	// every value is a float32 sent as two words, each word followed by its crc.
+	var word [4]byte
+	for i := 0; i < 6; i += 3 {
+		if sht3xCRC(buf[i:i+2]) != buf[i+2] {
+			return nil, fmt.Errorf("scd30 checksum mismatch")
+		}
+	}
+	copy(word[0:2], buf[0:2])
+	copy(word[2:4], buf[3:5])
+	co2 := new(float64)
+	*co2 = ToFixed(float64(math.Float32frombits(binary.BigEndian.Uint32(word[:]))), 0)
+	return co2, nil
+}
+
+// SimulatedSCD30 answers the SCD30 commands used by CO2Sensor with the concentration it was last set to.
+type SimulatedSCD30 struct {
+	mu      sync.Mutex
+	co2     float64
+	command []byte
+}
+
+// NewSimulatedSCD30 returns a simulated SCD30 reading the concentration (ppm) given.
+func NewSimulatedSCD30(co2 float64) *SimulatedSCD30 {
+	return &SimulatedSCD30{co2: co2}
+}
+
+// Set changes the concentration (ppm) returned by the next measurement.
+func (s *SimulatedSCD30) Set(co2 float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.co2 = co2
+}
+
+// WriteBytes keeps the command so the next read can answer it.
+func (s *SimulatedSCD30) WriteBytes(buf []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.command = append([]byte{}, buf...)
+	return len(buf), nil
+}
+
+// ReadBytes answers the last command written.
+func (s *SimulatedSCD30) ReadBytes(buf []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.command) < 2 {
+		return 0, fmt.Errorf("no command sent to the scd30")
+	}
+	var out []byte
+	switch {
+	case s.command[0] == scd30DataReady[0] && s.command[1] == scd30DataReady[1]:
+		out = []byte{0x00, 0x01, 0}
+		out[2] = sht3xCRC(out[0:2])
+	case s.command[0] == scd30ReadMeasurement[0] && s.command[1] == scd30ReadMeasurement[1]:
+		// co2 followed by a temperature and humidity the controller does not use.
+		for _, value := range []float32{float32(s.co2), 0, 0} {
+			bits := math.Float32bits(value)
+			high := []byte{byte(bits >> 24), byte(bits >> 16)}
+			low := []byte{byte(bits >> 8), byte(bits)}
+			out = append(out, high[0], high[1], sht3xCRC(high), low[0], low[1], sht3xCRC(low))
+		}
+	default:
+		return 0, fmt.Errorf("the simulated scd30 cannot answer command %#x", s.command)
+	}
+	return copy(buf, out), nil
+}
@@ -4,14 +4,11 @@ import (
 	"fmt"
 	"math"
 	"strings"
-
-	i2c "github.com/d2r2/go-i2c"
-	"github.com/only1isus/ADS1115"
+	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/only1isus/majorProj/config"
 	"github.com/only1isus/majorProj/consts"
-	"github.com/stianeikeland/go-rpio"
 )
 
 // Devices is a slice of all the output devices.
@@ -23,10 +20,15 @@ type Devices struct {
 type OutputDevice struct {
 	Name      consts.OutputDevice `yaml:"name"`
 	Pins      DriverPins          `yaml:"pins"`
+	Pin       uint8               `yaml:"pin"` // used instead of pins for devices switched by a relay.
 	Rate      float64             `yaml:"rate"`
 	OnTime    int64               `yaml:"onTime"`
 	Every     int64               `yaml:"every"`
 	Automatic bool                `yaml:"automatic"`
+	Schedules []Schedule          `yaml:"schedules"` // used instead of onTime and every when set.
+	SafeState switchAction        `yaml:"safeState"` // on or off, the state at shutdown. off when it is not set.
+
+	reason Reason // recorded with every switch, see With.
 }
 
 // DriverPins ...
@@ -37,10 +39,12 @@ type DriverPins struct {
 }
 
 type ADCSensor struct {
-	Name       consts.AnalogSensor `yaml:"name"`
-	Every      int64               `yaml:"every"`
-	AnalogPin  int                 `yaml:"analogPin"`
-	connection *ADS1115.ADS1115
+	Name       consts.AnalogSensor  `yaml:"name"`
+	Type       consts.AnalogSensor  `yaml:"type"` // the kind of sensor, the name is used when it is not set.
+	ADS        consts.ADS1115Device `yaml:"ads"`  // the first of the adsDevices is used when it is not set.
+	Every      int64                `yaml:"every"`
+	AnalogPin  int                  `yaml:"analogPin"`
+	connection ADC
 }
 
 // ADCSensor is a struct of the input sensor connected to the ADS1115 module.
@@ -49,11 +53,12 @@ type ADCSensors struct {
 }
 
 type I2CSensor struct {
-	Name       string `yaml:"name"`
-	Bus        int    `yaml:"bus"`
-	Address    uint8  `yaml:"address"`
-	Every      int64  `yaml:"every"`
-	connection *i2c.I2C
+	Name       string           `yaml:"name"`
+	Type       consts.I2CSensor `yaml:"type"` // the kind of sensor, the name is used when it is not set.
+	Bus        int              `yaml:"bus"`
+	Address    uint8            `yaml:"address"`
+	Every      int64            `yaml:"every"`
+	connection I2CDevice
 }
 
 type I2CSensors struct {
@@ -102,16 +107,18 @@ func NewI2CSensor(sensorName consts.I2CSensor) (*I2CSensor, error) {
 	return nil, fmt.Errorf("cannot find %s in i2cSensor setting", sensorName)
 }
 
-func NewADS1115Device(deviceName consts.ADS1115Device) (*ADS1115.ADS1115, error) {
-	type ADSDevice struct {
-		Name    consts.ADS1115Device `yaml:"name"`
-		Address int                  `yaml:"address"`
-		Bus     int                  `yaml:"bus"`
-	}
-	type ADSDevices struct {
-		ADSDevices []ADSDevice `yaml:"adsDevices"`
-	}
+// ADSDevice is an ADS1115 listed in the adsDevices section of the config file.
+type ADSDevice struct {
+	Name    consts.ADS1115Device `yaml:"name"`
+	Address int                  `yaml:"address"`
+	Bus     int                  `yaml:"bus"`
+}
+
+type ADSDevices struct {
+	ADSDevices []ADSDevice `yaml:"adsDevices"`
+}
 
+func readADSDevices() ([]ADSDevice, error) {
 	var adsDev ADSDevices
 	configFile, err := config.ReadConfigFile()
 	if err != nil {
@@ -121,15 +128,17 @@ func NewADS1115Device(deviceName consts.ADS1115Device) (*ADS1115.ADS1115, error)
 		fmt.Println("error unmarshalling", err)
 		return nil, err
 	}
+	return adsDev.ADSDevices, nil
+}
 
-	fmt.Println("device ", adsDev)
-	for _, adsdevice := range adsDev.ADSDevices {
+func NewADS1115Device(deviceName consts.ADS1115Device) (ADC, error) {
+	adsDevices, err := readADSDevices()
+	if err != nil {
+		return nil, err
+	}
+	for _, adsdevice := range adsDevices {
 		if strings.ToLower(string(adsdevice.Name)) == strings.ToLower(string(deviceName)) {
-			i2cconn, err := ADS1115.NewConnection(adsdevice.Address, adsdevice.Bus)
-			if err != nil {
-				return nil, err
-			}
-			ads1115Device := ADS1115.NewADS1115Device(i2cconn)
+			ads1115Device, err := CurrentHardware().OpenADC(adsdevice.Address, adsdevice.Bus)
 			if err != nil {
 				return nil, err
 			}
@@ -171,19 +180,58 @@ func NewOutputDevice(name consts.OutputDevice) (*OutputDevice, error) {
 	return device, nil
 }
 
+// usesRelay reports whether the device is switched by a relay on a single pin instead of a
+// motor driver.
+func (o OutputDevice) usesRelay() bool {
+	return o.Pins == DriverPins{}
+}
+
+// usedPins returns the pins the device drives.
+func (o OutputDevice) usedPins() []uint8 {
+	if o.usesRelay() {
+		return []uint8{o.Pin}
+	}
+	return []uint8{o.Pins.EN, o.Pins.IN1, o.Pins.IN2}
+}
+
+// switchRelay drives the pin of a relay switched device high or low. The rate is not used.
+func (o OutputDevice) switchRelay(on bool) error {
+	gpio, err := CurrentHardware().OpenGPIO()
+	if err != nil {
+		return err
+	}
+	defer gpio.Close()
+
+	pin := gpio.Pin(o.Pin)
+	pin.Output()
+	if on {
+		pin.High()
+	} else {
+		pin.Low()
+	}
+	tracker.record(o, on, o.Rate, time.Now())
+	return nil
+}
+
 func (o OutputDevice) On() error {
-	err := rpio.Open()
+	if err := checkSafety(o); err != nil {
+		return err
+	}
+	if o.usesRelay() {
+		return o.switchRelay(true)
+	}
+	gpio, err := CurrentHardware().OpenGPIO()
 	if err != nil {
 		return err
 	}
 
-	defer rpio.Close()
+	defer gpio.Close()
 
-	en := rpio.Pin(o.Pins.EN)
-	in1 := rpio.Pin(o.Pins.IN1)
-	in2 := rpio.Pin(o.Pins.IN2)
+	en := gpio.Pin(o.Pins.EN)
+	in1 := gpio.Pin(o.Pins.IN1)
+	in2 := gpio.Pin(o.Pins.IN2)
 	// en.Pwm()
-	rpio.StartPwm()
+	gpio.StartPwm()
 	en.Pwm()
 	in1.Output()
 	in2.Output()
@@ -193,21 +241,61 @@ func (o OutputDevice) On() error {
 	in1.High()
 	in2.Low()
 
+	tracker.record(o, true, o.Rate, time.Now())
 	return nil
 }
 
-func (o OutputDevice) OnNoPWM() error {
+// Reverse runs a device driven through a motor driver backwards at its rate, swapping IN1 and
+// IN2. It drains the beds through the circulation pump for example.
+func (o OutputDevice) Reverse() error {
+	if o.usesRelay() {
+		return fmt.Errorf("%s is switched by a relay and cannot run in reverse", o.Name)
+	}
+	if err := checkSafety(o); err != nil {
+		return err
+	}
+	gpio, err := CurrentHardware().OpenGPIO()
+	if err != nil {
+		return err
+	}
+	defer gpio.Close()
+
+	en := gpio.Pin(o.Pins.EN)
+	in1 := gpio.Pin(o.Pins.IN1)
+	in2 := gpio.Pin(o.Pins.IN2)
+	gpio.StartPwm()
+	en.Pwm()
+	in1.Output()
+	in2.Output()
+
+	// both inputs low brakes the motor before it turns the other way.
+	in1.Low()
+	in2.Low()
+	en.Freq(1920000)
+	en.DutyCycle(uint32(o.Rate*128), 128)
+	in2.High()
 
-	err := rpio.Open()
+	tracker.record(o, true, o.Rate, time.Now())
+	return nil
+}
+
+func (o OutputDevice) OnNoPWM() error {
+	if err := checkSafety(o); err != nil {
+		return err
+	}
+	if o.usesRelay() {
+		return o.switchRelay(true)
+	}
+	gpio, err := CurrentHardware().OpenGPIO()
 	if err != nil {
 		return err
 	}
 
-	defer rpio.Close()
+	defer gpio.Close()
 
-	en := rpio.Pin(o.Pins.EN)
-	in1 := rpio.Pin(o.Pins.IN1)
-	in2 := rpio.Pin(o.Pins.IN2)
+	en := gpio.Pin(o.Pins.EN)
+	in1 := gpio.Pin(o.Pins.IN1)
+	in2 := gpio.Pin(o.Pins.IN2)
 
 	en.Output()
 	in1.Output()
@@ -219,35 +307,49 @@ func (o OutputDevice) OnNoPWM() error {
 	in1.High()
 	in2.Low()
 
+	tracker.record(o, true, 1, time.Now())
 	return nil
 }
 
+// ChangePWM sets the rate of a device driven through a motor driver. A device that is off is
+// turned on by it, so the safety is checked first.
 func (o OutputDevice) ChangePWM(rate float64) error {
-	err := rpio.Open()
+	if o.usesRelay() {
+		return fmt.Errorf("%s is switched by a relay and cannot change its rate", o.Name)
+	}
+	if state, _ := tracker.state(o.Name, time.Now()); !state.On {
+		if err := checkSafety(o); err != nil {
+			return err
+		}
+	}
+	gpio, err := CurrentHardware().OpenGPIO()
 	if err != nil {
 		return err
 	}
-	defer rpio.Close()
+	defer gpio.Close()
 
-	rpio.StartPwm()
-	en := rpio.Pin(o.Pins.EN)
+	gpio.StartPwm()
+	en := gpio.Pin(o.Pins.EN)
 	en.Pwm()
 	en.DutyCycle(uint32(rate*128), 128)
+	tracker.record(o, true, rate, time.Now())
 	return nil
 }
 
 // Off method turns the fan off.
 func (o OutputDevice) Off() error {
-	err := rpio.Open()
-	defer rpio.Close()
-
+	if o.usesRelay() {
+		return o.switchRelay(false)
+	}
+	gpio, err := CurrentHardware().OpenGPIO()
 	if err != nil {
 		return err
 	}
+	defer gpio.Close()
 
-	en := rpio.Pin(o.Pins.EN)
-	in1 := rpio.Pin(o.Pins.IN1)
-	in2 := rpio.Pin(o.Pins.IN2)
+	en := gpio.Pin(o.Pins.EN)
+	in1 := gpio.Pin(o.Pins.IN1)
+	in2 := gpio.Pin(o.Pins.IN2)
 	en.Output()
 	in1.Output()
 	in2.Output()
@@ -255,8 +357,9 @@ func (o OutputDevice) Off() error {
 	en.Low()
 	in1.Low()
 	in2.Low()
-	rpio.StopPwm()
+	// the pwm clock is shared by every device so it is left running for the others.
 
+	tracker.record(o, false, 0, time.Now())
 	return nil
 }
 
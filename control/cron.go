@@ -0,0 +1,117 @@
+package control
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronExpression is a standard five field cron expression: minute, hour, day of month, month and
+// day of week. Each field takes *, a value, a range (1-5), a step (*/15 or 0-30/10) or a list of
+// those separated by commas. Days of the week run from 0 (sunday) to 6, 7 is also sunday.
+type cronExpression struct {
+	minute, hour, dom, month, dow uint64
+	// as in cron, when both days are restricted a time matches when either day matches. A day
+	// starting with *, */2 for example, is not restricted.
+	domRestricted, dowRestricted bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+func parseCron(expression string) (*cronExpression, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("the cron expression %q needs 5 fields: minute hour day-of-month month day-of-week", expression)
+	}
+	var bits [5]uint64
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %v", expression, err)
+		}
+		bits[i] = b
+	}
+	// 7 is sunday as well.
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &cronExpression{
+		minute:        bits[0],
+		hour:          bits[1],
+		dom:           bits[2],
+		month:         bits[3],
+		dow:           bits[4],
+		domRestricted: !strings.HasPrefix(fields[2], "*"),
+		dowRestricted: !strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step in the %s field %q", f.name, part)
+			}
+			rangePart, step = part[:i], s
+		}
+
+		low, high := f.min, f.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid range in the %s field %q", f.name, part)
+			}
+			if high, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid range in the %s field %q", f.name, part)
+			}
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in the %s field %q", f.name, part)
+			}
+			low, high = value, value
+			// a single value with a step runs from the value to the end of the field, as in cron.
+			if step > 1 {
+				high = f.max
+			}
+		}
+		if low < f.min || high > f.max || low > high {
+			return 0, fmt.Errorf("the %s field %q is outside %d-%d", f.name, part, f.min, f.max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// matches reports whether the expression fires in the minute of t.
+func (c *cronExpression) matches(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 || c.hour&(1<<uint(t.Hour())) == 0 || c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domRestricted && c.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
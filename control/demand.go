@@ -0,0 +1,69 @@
+package control
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+)
+
+// demand shares a device between the control loops that need it, the cooling fan between the
+// temperature and the humidity control for example. The device runs at the highest rate asked
+// for and is only turned off once no loop needs it, so the loops never switch it against each
+// other.
+type demand struct {
+	mu    sync.Mutex
+	rates map[consts.OutputDevice]map[string]float64
+}
+
+var demands = &demand{rates: map[consts.OutputDevice]map[string]float64{}}
+
+// request sets the rate the loop needs the device at, 0 when it does not need it, and drives
+// the device at the highest rate requested. It returns the rate the device runs at and the loop
+// asking for it, empty when the device is off. The device is switched with its reason, see With.
+func (d *demand) request(device OutputDevice, loop string, rate float64) (float64, string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rates, ok := d.rates[device.Name]
+	if !ok {
+		rates = map[string]float64{}
+		d.rates[device.Name] = rates
+	}
+	rates[loop] = rate
+
+	// the loops are sorted so a tie always goes to the same loop.
+	loops := make([]string, 0, len(rates))
+	for name := range rates {
+		loops = append(loops, name)
+	}
+	sort.Strings(loops)
+	highest, by := 0.0, ""
+	for _, name := range loops {
+		if rates[name] > highest {
+			highest, by = rates[name], name
+		}
+	}
+
+	state, _ := tracker.state(device.Name, time.Now())
+	switch {
+	case highest <= 0:
+		// a device turned on by a rule or a schedule is left to it.
+		if state.On && state.Reason == string(device.reason) {
+			if err := device.Off(); err != nil {
+				return state.Rate, "", err
+			}
+		}
+		return 0, "", nil
+	case !state.On:
+		device.Rate = highest
+		if err := device.On(); err != nil {
+			return 0, "", err
+		}
+	case !device.usesRelay() && state.Rate != highest:
+		if err := device.ChangePWM(highest); err != nil {
+			return state.Rate, by, err
+		}
+	}
+	return highest, by, nil
+}
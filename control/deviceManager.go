@@ -0,0 +1,175 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+// DeviceManager holds every output device listed under devices in the config file.
+//
+// Devices set as automatic are left to the loop that controls them, the cooling fan PID or the
+// ph dosing for example. Devices with schedules follow them, see Schedule. The others are run on
+// a cycle: on for onTime minutes then off for every minutes. A device with an onTime but no
+// every is kept on and a device without an onTime is kept off.
+type DeviceManager struct {
+	devices []*OutputDevice
+}
+
+// NewDeviceManager creates every device under the devices setting.
+func NewDeviceManager() (*DeviceManager, error) {
+	var d Devices
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &d); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	return newDeviceManager(d.Devices)
+}
+
+func newDeviceManager(devices []OutputDevice) (*DeviceManager, error) {
+	m := &DeviceManager{}
+	names := map[consts.OutputDevice]bool{}
+	pins := map[uint8]consts.OutputDevice{}
+	for i := range devices {
+		device := devices[i]
+		if names[device.Name] {
+			return nil, fmt.Errorf("the device %s is set more than once", device.Name)
+		}
+		names[device.Name] = true
+		for _, pin := range device.usedPins() {
+			if other, ok := pins[pin]; ok {
+				return nil, fmt.Errorf("%s and %s both use pin %d", other, device.Name, pin)
+			}
+			pins[pin] = device.Name
+		}
+		if device.Rate < 0 || device.Rate > 1 {
+			return nil, fmt.Errorf("%s needs a rate between 0 and 1", device.Name)
+		}
+		if device.OnTime < 0 || device.Every < 0 {
+			return nil, fmt.Errorf("%s cannot have a negative onTime or every", device.Name)
+		}
+		if device.SafeState != "" && device.SafeState != "on" && device.SafeState != "off" {
+			return nil, fmt.Errorf("the safeState of %s needs to be on or off, got %q", device.Name, device.SafeState)
+		}
+		if len(device.Schedules) > 0 && device.Automatic {
+			return nil, fmt.Errorf("%s is automatic and cannot have schedules", device.Name)
+		}
+		for _, s := range device.Schedules {
+			if _, err := s.compile(); err != nil {
+				return nil, fmt.Errorf("%s: %v", device.Name, err)
+			}
+		}
+		m.devices = append(m.devices, &device)
+	}
+	return m, nil
+}
+
+// Device returns the device named in the config file.
+func (m *DeviceManager) Device(name consts.OutputDevice) (*OutputDevice, error) {
+	for _, device := range m.devices {
+		if device.Name == name {
+			return device, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot find %s in the devices setting", name)
+}
+
+// Devices returns every device in the order of the config file.
+func (m *DeviceManager) Devices() []*OutputDevice {
+	return append([]*OutputDevice{}, m.devices...)
+}
+
+// Run starts the schedules or the cycle of every device not set as automatic under the
+// supervisor, until ctx is done. Failures to switch a device are sent over the entry channel.
+func (m *DeviceManager) Run(ctx context.Context, s *Supervisor, entry chan *types.LogEntry) {
+	for _, device := range m.devices {
+		device := device
+		switch {
+		case device.Automatic:
+		case len(device.Schedules) > 0:
+			s.Go(ctx, string(device.Name)+" schedule", func(ctx context.Context) error {
+				return device.FollowSchedules(ctx, entry)
+			})
+		default:
+			s.Go(ctx, string(device.Name)+" cycle", func(ctx context.Context) error {
+				return m.cycle(ctx, device, entry)
+			})
+		}
+	}
+}
+
+func (m *DeviceManager) cycle(ctx context.Context, device *OutputDevice, entry chan *types.LogEntry) error {
+	d := device.With(ReasonSchedule)
+	switchDevice := func(on bool) {
+		action, switchFn := "off", d.Off
+		if on {
+			action, switchFn = "on", d.On
+		}
+		if err := switchFn(); err != nil {
+			entry <- &types.LogEntry{
+				Message: fmt.Sprintf("Something went wrong turning %s %s %v", device.Name, action, err),
+				Success: false,
+				Time:    time.Now().Unix(),
+				Type:    string(device.Name),
+			}
+		}
+	}
+
+	if device.OnTime == 0 {
+		switchDevice(false)
+		return nil
+	}
+	for {
+		switchDevice(true)
+		if device.Every == 0 {
+			return nil
+		}
+		if err := sleep(ctx, time.Minute*time.Duration(device.OnTime)); err != nil {
+			return err
+		}
+		switchDevice(false)
+		if err := sleep(ctx, time.Minute*time.Duration(device.Every)); err != nil {
+			return err
+		}
+	}
+}
+
+// SafeState turns every device to its safe state, off unless its safeState is on. A device that
+// cannot be turned on, held off by an interlock for example, is turned off.
+func (m *DeviceManager) SafeState() error {
+	var first error
+	for _, device := range m.devices {
+		d := device.With(ReasonSafety)
+		if device.SafeState == "on" {
+			err := d.On()
+			if err == nil {
+				continue
+			}
+			fmt.Printf("cannot leave %s on, turning it off. %v\n", device.Name, err)
+		}
+		if err := d.Off(); err != nil && first == nil {
+			first = fmt.Errorf("cannot turn %s off %v", device.Name, err)
+		}
+	}
+	return first
+}
+
+// Off turns every device off, recorded as a switch for safety.
+func (m *DeviceManager) Off() error {
+	var first error
+	for _, device := range m.devices {
+		if err := device.With(ReasonSafety).Off(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,78 @@
+package control
+
+import (
+	"testing"
+
+	"github.com/only1isus/majorProj/consts"
+)
+
+func TestDeviceManager(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+
+	m, err := newDeviceManager([]OutputDevice{
+		{Name: consts.CoolingFan, Pins: DriverPins{EN: 22, IN1: 27, IN2: 17}, Rate: 1, Automatic: true},
+		{Name: consts.AirPump, Pin: 4, OnTime: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	airPump, err := m.Device(consts.AirPump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := airPump.On(); err != nil {
+		t.Fatal(err)
+	}
+	if state := hw.PinState(4); state.Mode != "output" || !state.High {
+		t.Errorf("expected the relay pin high, got %+v", state)
+	}
+	if err := m.Off(); err != nil {
+		t.Fatal(err)
+	}
+	if hw.PinState(4).High {
+		t.Error("expected the relay pin low after turning every device off")
+	}
+	if _, err := m.Device(consts.GrowLight); err == nil {
+		t.Error("expected an error for a device that is not set")
+	}
+
+	if _, err := newDeviceManager([]OutputDevice{
+		{Name: consts.GrowLight, Pins: DriverPins{EN: 21, IN1: 20, IN2: 16}},
+		{Name: consts.CirculationPump, Pin: 16},
+	}); err == nil {
+		t.Error("expected an error for two devices sharing a pin")
+	}
+}
+
+func TestSafeState(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+
+	m, err := newDeviceManager([]OutputDevice{
+		{Name: consts.GrowLight, Pins: DriverPins{EN: 21, IN1: 20, IN2: 16}, Rate: 0.7},
+		{Name: consts.CirculationPump, Pin: 25, SafeState: "on"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	light, _ := m.Device(consts.GrowLight)
+	if err := light.On(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SafeState(); err != nil {
+		t.Fatal(err)
+	}
+	if hw.PinState(20).High {
+		t.Error("expected the grow light off in its safe state")
+	}
+	if !hw.PinState(25).High {
+		t.Error("expected the circulation pump left on in its safe state")
+	}
+
+	if _, err := newDeviceManager([]OutputDevice{{Name: consts.AirPump, Pin: 4, SafeState: "open"}}); err == nil {
+		t.Error("expected an error for a safe state that is not on or off")
+	}
+}
@@ -0,0 +1,208 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+// Reason is why an output device was switched.
+type Reason string
+
+const (
+	// ReasonSchedule is a device switched by its schedules, its cycle or the photoperiod.
+	ReasonSchedule Reason = "schedule"
+	// ReasonRule is a device switched by a rule.
+	ReasonRule Reason = "rule"
+	// ReasonControl is a device switched by a control loop, the fan PID or the dosing for example.
+	ReasonControl Reason = "control"
+	// ReasonManual is a device switched directly. Devices without a reason are switched manually.
+	ReasonManual Reason = "manual"
+	// ReasonSafety is a device switched to keep the system safe, at shutdown for example.
+	ReasonSafety Reason = "safety"
+)
+
+// With returns a copy of the device that records reason with every switch.
+func (o OutputDevice) With(reason Reason) OutputDevice {
+	o.reason = reason
+	return o
+}
+
+// trackerQueueSize is the number of switches waiting to be sent over the entry channel.
+const trackerQueueSize = 64
+
+// deviceTracker keeps the state, runtime and cycles of every device that was switched.
+type deviceTracker struct {
+	mu     sync.Mutex
+	states map[consts.OutputDevice]*types.DeviceState
+	// queue and persist are set by TrackDevices. Until then the states are only kept in memory.
+	queue   chan *types.LogEntry
+	persist bool
+}
+
+var tracker = &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+
+// TrackDevices loads the runtime and cycles saved by the last run and starts saving them on every
+// change. Each switch of a device is sent over the entry channel with its state so the server
+// keeps the state of every device. A device left on when the controller stopped is taken as off,
+// its runtime counted up to when it was turned on. Every device starts switched off for safety,
+// the reason it was last switched for is not kept.
+func TrackDevices(entry chan *types.LogEntry) error {
+	data, err := config.ReadDataFile(config.DeviceStateName)
+	if err != nil {
+		return err
+	}
+	var saved []types.DeviceState
+	if data != nil {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("cannot read %s. %v", config.DeviceStateName, err)
+		}
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	for i := range saved {
+		state := saved[i]
+		state.On, state.Rate, state.Reason = false, 0, string(ReasonSafety)
+		tracker.states[consts.OutputDevice(state.Device)] = &state
+	}
+	tracker.forward(entry)
+	tracker.persist = true
+	return nil
+}
+
+// forward sends every switch over the entry channel, in the order the devices were switched.
+// The switches are queued so a device can be switched from the loop reading entry.
+func (t *deviceTracker) forward(entry chan *types.LogEntry) {
+	queue := make(chan *types.LogEntry, trackerQueueSize)
+	t.queue = queue
+	Go(func() {
+		for logEntry := range queue {
+			entry <- logEntry
+		}
+	})
+}
+
+// DeviceStates returns the state of every device switched since the controller started, or
+// saved by the last run. The runtime of a device that is on includes the time since it was
+// turned on.
+func DeviceStates() []types.DeviceState {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	return tracker.snapshot(time.Now())
+}
+
+// state returns the state of the device with the runtime of the current run.
+func (t *deviceTracker) state(name consts.OutputDevice, now time.Time) (types.DeviceState, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, ok := t.states[name]
+	if !ok {
+		return types.DeviceState{}, false
+	}
+	return live(state, now), true
+}
+
+func (t *deviceTracker) snapshot(now time.Time) []types.DeviceState {
+	states := make([]types.DeviceState, 0, len(t.states))
+	for _, state := range t.states {
+		states = append(states, live(state, now))
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Device < states[j].Device })
+	return states
+}
+
+// live returns a copy of the state with the runtime of the current run added.
+func live(state *types.DeviceState, now time.Time) types.DeviceState {
+	s := *state
+	if s.On && now.Unix() > s.Since {
+		s.Runtime += now.Unix() - s.Since
+	}
+	return s
+}
+
+// record stores a switch of the device. Nothing is recorded when the state does not change.
+func (t *deviceTracker) record(o OutputDevice, on bool, rate float64, now time.Time) {
+	reason := o.reason
+	if reason == "" {
+		reason = ReasonManual
+	}
+	if !on || o.usesRelay() {
+		rate = 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, ok := t.states[o.Name]
+	if !ok {
+		state = &types.DeviceState{Device: string(o.Name)}
+		t.states[o.Name] = state
+	}
+	if ok && state.Since != 0 && state.On == on && state.Rate == rate {
+		return
+	}
+	// a change of rate keeps the time the device was turned on.
+	switched := !ok || state.On != on
+	if switched {
+		if state.On && now.Unix() > state.Since {
+			state.Runtime += now.Unix() - state.Since
+		}
+		if on {
+			state.Cycles++
+		}
+		state.Since = now.Unix()
+	}
+	state.On, state.Rate, state.Reason = on, rate, string(reason)
+
+	// only the switches are saved and sent, a change of rate alone does not change the runtime.
+	if !switched {
+		return
+	}
+	if t.persist {
+		if err := t.save(now); err != nil {
+			fmt.Println("could not save the state of the devices", err)
+		}
+	}
+	if t.queue != nil {
+		current := *state
+		logEntry := &types.LogEntry{
+			Message: describeState(current),
+			Success: true,
+			Time:    now.Unix(),
+			Type:    current.Device,
+			Device:  &current,
+		}
+		// queued under the lock so the switches keep their order. A full queue means the entries
+		// are not read, the switch is dropped rather than blocking the device.
+		select {
+		case t.queue <- logEntry:
+		default:
+			fmt.Println("could not send the switch of", current.Device, "the queue is full")
+		}
+	}
+}
+
+func (t *deviceTracker) save(now time.Time) error {
+	data, err := json.Marshal(t.snapshot(now))
+	if err != nil {
+		return err
+	}
+	return config.WriteDataFile(config.DeviceStateName, data)
+}
+
+func describeState(s types.DeviceState) string {
+	switch {
+	case !s.On:
+		return fmt.Sprintf("%s turned off (%s)", s.Device, s.Reason)
+	case s.Rate > 0:
+		return fmt.Sprintf("%s turned on at %v (%s)", s.Device, s.Rate, s.Reason)
+	default:
+		return fmt.Sprintf("%s turned on (%s)", s.Device, s.Reason)
+	}
+}
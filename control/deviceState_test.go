@@ -0,0 +1,74 @@
+package control
+
+import (
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+func TestDeviceTracker(t *testing.T) {
+	tr := &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	entry := make(chan *types.LogEntry, 10)
+	tr.forward(entry)
+	fan := OutputDevice{Name: consts.CoolingFan, Pins: DriverPins{EN: 22, IN1: 27, IN2: 17}}
+	start := time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC)
+
+	tr.record(fan.With(ReasonControl), true, 0.5, start)
+	tr.record(fan.With(ReasonControl), true, 0.5, start.Add(time.Minute))
+	tr.record(fan.With(ReasonControl), true, 0.8, start.Add(2*time.Minute))
+	tr.record(fan, false, 0, start.Add(5*time.Minute))
+	tr.record(fan.With(ReasonRule), true, 1, start.Add(10*time.Minute))
+
+	state := tr.states[consts.CoolingFan]
+	if state.Runtime != 300 {
+		t.Errorf("expected 300 seconds of runtime, got %d", state.Runtime)
+	}
+	if state.Cycles != 2 {
+		t.Errorf("expected 2 cycles, got %d", state.Cycles)
+	}
+	if !state.On || state.Rate != 1 || state.Reason != string(ReasonRule) {
+		t.Errorf("expected the fan on at 1 by a rule, got %+v", state)
+	}
+	live := tr.snapshot(start.Add(11 * time.Minute))
+	if len(live) != 1 || live[0].Runtime != 360 {
+		t.Errorf("expected 360 seconds of runtime including the current run, got %+v", live)
+	}
+
+	// the repeated switch at the same rate and the change of rate are not transitions.
+	for i, want := range []types.DeviceState{
+		{On: true, Reason: string(ReasonControl)},
+		{On: false, Reason: string(ReasonManual)},
+		{On: true, Reason: string(ReasonRule)},
+	} {
+		select {
+		case e := <-entry:
+			if e.Device == nil {
+				t.Fatalf("expected the state with the entry %+v", e)
+			}
+			if e.Device.On != want.On || e.Device.Reason != want.Reason {
+				t.Errorf("transition %d: expected on %v by %s, got %+v", i, want.On, want.Reason, e.Device)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected 3 transitions, got %d", i)
+		}
+	}
+	select {
+	case e := <-entry:
+		t.Errorf("unexpected transition %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRelayRate(t *testing.T) {
+	tr := &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	pump := OutputDevice{Name: consts.AirPump, Pin: 4, Rate: 0.5}
+	now := time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC)
+
+	tr.record(pump.With(ReasonSchedule), true, pump.Rate, now)
+	state := tr.states[consts.AirPump]
+	if !state.On || state.Rate != 0 || state.Reason != string(ReasonSchedule) || state.Cycles != 1 {
+		t.Errorf("expected the relay on without a rate, got %+v", state)
+	}
+}
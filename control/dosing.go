@@ -0,0 +1,156 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/only1isus/majorProj/config"
+)
+
+// doseHistoryKept is how long the doses are kept in the dose file, the longest window a limit
+// is enforced over.
+const doseHistoryKept = 24 * time.Hour
+
+// dose is a single run of a dosing pump.
+type dose struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// savedDose is a dose in the dose file.
+type savedDose struct {
+	At       int64   `json:"at"`
+	Duration float64 `json:"duration"` // seconds
+}
+
+// doseHistory keeps the doses given by a pump so a limit can be enforced over a rolling window.
+// A history loaded from the dose file saves every dose added under its name, so the limit
+// still holds after a restart.
+type doseHistory struct {
+	mu    sync.Mutex
+	doses []dose
+	name  string // empty when the doses are not saved.
+}
+
+// doseFileMu guards the dose file, the ph and the nutrient dosing save to the same file.
+var doseFileMu sync.Mutex
+
+// loadDoseHistory returns the history saved under name, the doses older than doseHistoryKept
+// are left out. The history is empty when nothing was saved yet.
+func loadDoseHistory(name string, now time.Time) (*doseHistory, error) {
+	doseFileMu.Lock()
+	defer doseFileMu.Unlock()
+
+	h := &doseHistory{name: name}
+	saved, err := readDoseFile()
+	if err != nil {
+		return h, err
+	}
+	for _, d := range saved[name] {
+		at := time.Unix(d.At, 0)
+		if now.Sub(at) < doseHistoryKept {
+			h.doses = append(h.doses, dose{at: at, duration: time.Duration(d.Duration * float64(time.Second))})
+		}
+	}
+	return h, nil
+}
+
+// add records a dose that started at t.
+func (h *doseHistory) add(t time.Time, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.doses = append(h.doses, dose{at: t, duration: d})
+	if h.name == "" {
+		return
+	}
+	if err := h.save(t); err != nil {
+		fmt.Println("could not save the doses of", h.name, err)
+	}
+}
+
+// save replaces the doses saved under the name of the history, the doses older than
+// doseHistoryKept are dropped.
+func (h *doseHistory) save(now time.Time) error {
+	doseFileMu.Lock()
+	defer doseFileMu.Unlock()
+
+	// an unreadable file is replaced, its doses could not be loaded either.
+	saved, _ := readDoseFile()
+	var doses []savedDose
+	for _, d := range h.doses {
+		if now.Sub(d.at) < doseHistoryKept {
+			doses = append(doses, savedDose{At: d.at.Unix(), Duration: d.duration.Seconds()})
+		}
+	}
+	saved[h.name] = doses
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return config.WriteDataFile(config.DoseHistoryName, data)
+}
+
+func readDoseFile() (map[string][]savedDose, error) {
+	saved := map[string][]savedDose{}
+	data, err := config.ReadDataFile(config.DoseHistoryName)
+	if err != nil {
+		return saved, err
+	}
+	if data == nil {
+		return saved, nil
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return map[string][]savedDose{}, err
+	}
+	return saved, nil
+}
+
+// within returns the total pump time of the doses started in the window before now. Doses older
+// than the window are dropped.
+func (h *doseHistory) within(now time.Time, window time.Duration) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var total time.Duration
+	kept := h.doses[:0]
+	for _, d := range h.doses {
+		if now.Sub(d.at) >= window {
+			continue
+		}
+		kept = append(kept, d)
+		total += d.duration
+	}
+	h.doses = kept
+	return total
+}
+
+// dosingLock is held by a dosing controller from the start of a dose until the solution is mixed,
+// so the pH and the nutrients are never dosed at the same time.
+var dosingLock = make(chan struct{}, 1)
+
+// lockDosing waits for the dose of the other controllers to be mixed, or for ctx to be done.
+func lockDosing(ctx context.Context) error {
+	select {
+	case dosingLock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func unlockDosing() {
+	<-dosingLock
+}
+
+// pulse runs the device for d then turns it off.
+func pulse(device *OutputDevice, d time.Duration) error {
+	pump := device.With(ReasonControl)
+	if err := pump.On(); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return pump.Off()
+}
@@ -0,0 +1,49 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDoseHistoryWindow(t *testing.T) {
+	now := time.Now()
+	h := &doseHistory{}
+	h.add(now.Add(-90*time.Minute), 5*time.Second)
+	h.add(now.Add(-30*time.Minute), 2*time.Second)
+	h.add(now.Add(-time.Minute), 2*time.Second)
+
+	if total := h.within(now, time.Hour); total != 4*time.Second {
+		t.Errorf("expected 4s of dosing in the last hour, got %v", total)
+	}
+	if len(h.doses) != 2 {
+		t.Errorf("expected the dose older than the window to be dropped, got %d doses", len(h.doses))
+	}
+}
+
+func TestDoseHistorySaved(t *testing.T) {
+	inTempDir(t)
+	now := time.Now()
+	ph, err := loadDoseHistory("phuppump", now)
+	if err != nil || len(ph.doses) != 0 {
+		t.Fatalf("expected an empty history before a dose is saved, got %v %v", ph.doses, err)
+	}
+	ph.add(now.Add(-time.Minute), 2*time.Second)
+	nutrients, err := loadDoseHistory(nutrientDoses, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nutrients.add(now.Add(-25*time.Hour), 10*time.Second)
+	nutrients.add(now.Add(-time.Hour), 7500*time.Millisecond)
+
+	// after a restart.
+	nutrients, err = loadDoseHistory(nutrientDoses, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := nutrients.within(now, 24*time.Hour); total != 7500*time.Millisecond {
+		t.Errorf("expected 7.5s of nutrients dosed in the last 24 hours, got %v", total)
+	}
+	if ph, err = loadDoseHistory("phuppump", now); err != nil || ph.within(now, time.Hour) != 2*time.Second {
+		t.Errorf("expected the ph dose kept beside the nutrient doses, got %v %v", ph.doses, err)
+	}
+}
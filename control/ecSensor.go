@@ -0,0 +1,163 @@
+package control
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+)
+
+// ECSensor is an electrical conductivity probe connected to the ADS1115. Readings are in mS/cm
+// compensated to 25c. Its calibration is kept in the calibration file under the name of the
+// sensor.
+type ECSensor struct {
+	ADCSensor
+	Calibration      ECCalibration
+	waterTemperature Sensor // nil without a water temperature sensor.
+}
+
+// ECCalibration is the ecCalibration section of the config file. The conductivity at the
+// temperature of the water is slope * voltage + offset.
+type ECCalibration struct {
+	Slope                  float64 `yaml:"slope"`                  // mS/cm per volt
+	Offset                 float64 `yaml:"offset"`                 // mS/cm
+	TemperatureCoefficient float64 `yaml:"temperatureCoefficient"` // fraction per degree c, 0.02 for most nutrient solutions.
+	WaterTemperature       float64 `yaml:"waterTemperature"`       // used by Get without a water temperature sensor.
+}
+
+// ECCalibrationRecord is the calibration of an ec probe saved in the calibration file. It
+// replaces the slope and offset of the ecCalibration setting.
+type ECCalibrationRecord struct {
+	Time   int64   `json:"time"`
+	Slope  float64 `json:"slope"`  // mS/cm per volt
+	Offset float64 `json:"offset"` // mS/cm
+}
+
+// ECReference is the voltage read from the probe while it sits in a reference solution.
+type ECReference struct {
+	Conductivity float64 // mS/cm at 25c, printed on the solution.
+	Temperature  float64 // c, of the solution when it was read.
+	Voltage      float64
+}
+
+type ecCalibrationConfig struct {
+	ECCalibration ECCalibration `yaml:"ecCalibration"`
+}
+
+var defaultECCalibration = ECCalibration{
+	Slope:                  1,
+	TemperatureCoefficient: 0.02,
+	WaterTemperature:       25,
+}
+
+// NewECSensor returns the ec sensor from the analogSensor setting using the calibration saved
+// for it. The slope and offset of the ecCalibration setting are used until the sensor is
+// calibrated.
+func NewECSensor(connection ADC) (*ECSensor, error) {
+	ecsensor, err := NewAnalogSensor(consts.ECSensor)
+	if err != nil {
+		return nil, err
+	}
+	return newECSensor(*ecsensor, connection)
+}
+
+func newECSensor(sensor ADCSensor, connection ADC) (*ECSensor, error) {
+	sensor.connection = connection
+	setting := ecCalibrationConfig{ECCalibration: defaultECCalibration}
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	ec := &ECSensor{ADCSensor: sensor, Calibration: setting.ECCalibration}
+	var record ECCalibrationRecord
+	ok, err := loadCalibration(string(ec.Name), &record)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		ec.Calibration.Slope, ec.Calibration.Offset = record.Slope, record.Offset
+	}
+	return ec, nil
+}
+
+// compensate returns the conductivity at 25c of a solution measuring ec at temperature.
+func (c ECCalibration) compensate(ec, temperature float64) float64 {
+	return ec / (1 + c.TemperatureCoefficient*(temperature-25))
+}
+
+// ReadReference reads the probe while it sits in a reference solution of the conductivity
+// (mS/cm at 25c) and temperature given. The result is passed to Calibrate.
+func (ec *ECSensor) ReadReference(conductivity, temperature float64) (*ECReference, error) {
+	voltage, err := ec.connection.Read(ec.AnalogPin)
+	if err != nil {
+		return nil, err
+	}
+	return &ECReference{Conductivity: conductivity, Temperature: temperature, Voltage: voltage}, nil
+}
+
+// Calibrate sets the slope, and the offset when two references are given, from the readings
+// taken in reference solutions. The calibration is saved and used from then on.
+func (ec *ECSensor) Calibrate(references ...ECReference) error {
+	// the references are rated at 25c, the probe saw the conductivity at the solution temperature.
+	actual := func(r ECReference) float64 {
+		return r.Conductivity * (1 + ec.Calibration.TemperatureCoefficient*(r.Temperature-25))
+	}
+	record := ECCalibrationRecord{Time: time.Now().Unix()}
+	switch len(references) {
+	case 1:
+		r := references[0]
+		if r.Voltage == 0 {
+			return fmt.Errorf("the probe read 0v in the reference solution")
+		}
+		record.Slope = actual(r) / r.Voltage
+	case 2:
+		low, high := references[0], references[1]
+		if low.Voltage == high.Voltage {
+			return fmt.Errorf("the probe read the same voltage in both reference solutions")
+		}
+		record.Slope = (actual(high) - actual(low)) / (high.Voltage - low.Voltage)
+		record.Offset = actual(low) - record.Slope*low.Voltage
+	default:
+		return fmt.Errorf("calibration takes 1 or 2 reference solutions, got %d", len(references))
+	}
+	if err := saveCalibration(string(ec.Name), record); err != nil {
+		return err
+	}
+	ec.Calibration.Slope, ec.Calibration.Offset = record.Slope, record.Offset
+	return nil
+}
+
+// Get returns the conductivity compensated using the water temperature sensor, or the
+// waterTemperature setting when there is none.
+func (ec *ECSensor) Get() (*float64, error) {
+	if ec.waterTemperature == nil {
+		return ec.GetCompensated(ec.Calibration.WaterTemperature)
+	}
+	temperature, err := ec.waterTemperature.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the water temperature to compensate the EC. %v", err)
+	}
+	return ec.GetCompensated(temperature)
+}
+
+// GetCompensated returns the conductivity of water at the temperature given, compensated to 25c.
+func (ec *ECSensor) GetCompensated(waterTemperature float64) (*float64, error) {
+	voltage, err := ec.connection.Read(ec.AnalogPin)
+	if err != nil {
+		return nil, err
+	}
+	raw := ec.Calibration.Slope*voltage + ec.Calibration.Offset
+	out := new(float64)
+	*out = ToFixed(ec.Calibration.compensate(raw, waterTemperature), 2)
+	return out, nil
+}
+
+func (ec *ECSensor) Close() error {
+	return ec.connection.Close()
+}
@@ -0,0 +1,74 @@
+package control
+
+import (
+	"math"
+	"testing"
+
+	"github.com/only1isus/majorProj/consts"
+)
+
+func TestECCalibration(t *testing.T) {
+	inTempDir(t)
+	adc := &SimulatedADC{values: map[int]float64{}}
+	ec := &ECSensor{
+		ADCSensor:   ADCSensor{Name: consts.ECSensor, AnalogPin: 2, connection: adc},
+		Calibration: defaultECCalibration,
+	}
+
+	// a probe reading 0.5v per mS/cm with a 0.1v offset, both solutions read at 25c.
+	adc.Set(2, 1.413*0.5+0.1)
+	low, err := ec.ReadReference(1.413, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adc.Set(2, 12.88*0.5+0.1)
+	high, err := ec.ReadReference(12.88, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ec.Calibrate(*low, *high); err != nil {
+		t.Fatal(err)
+	}
+	var record ECCalibrationRecord
+	if ok, err := loadCalibration(string(consts.ECSensor), &record); !ok || err != nil || record.Slope != ec.Calibration.Slope || record.Offset != ec.Calibration.Offset {
+		t.Errorf("expected the calibration saved, got %+v %v %v", record, ok, err)
+	}
+
+	adc.Set(2, 2*0.5+0.1)
+	value, err := ec.GetCompensated(25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(*value-2) > 0.01 {
+		t.Errorf("expected 2 mS/cm, got %v", *value)
+	}
+
+	// the same voltage in warmer water is a lower conductivity at 25c.
+	warm, err := ec.GetCompensated(30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(*warm-2/1.1) > 0.01 {
+		t.Errorf("expected %v mS/cm once compensated, got %v", 2/1.1, *warm)
+	}
+}
+
+func TestECCompensatedByTheWaterTemperature(t *testing.T) {
+	adc := &SimulatedADC{values: map[int]float64{}}
+	water := &WaterTemperatureSensor{ADCSensor: ADCSensor{AnalogPin: 3, connection: adc}, Thermistor: defaultThermistor}
+	ec := &ECSensor{
+		ADCSensor:   ADCSensor{AnalogPin: 2, connection: adc},
+		Calibration: defaultECCalibration,
+	}
+
+	// 2 mS/cm at 25c read in water at 30c.
+	adc.Set(2, 2*1.1)
+	adc.Set(3, defaultThermistor.Voltage(30))
+	if value, err := ec.Get(); err != nil || *value != 2.2 {
+		t.Errorf("expected the waterTemperature setting of 25c without a water temperature sensor, got %v %v", value, err)
+	}
+	ec.waterTemperature = &sensor{name: "watertemperature", kind: consts.WaterTemperature, device: water, read: water.Get}
+	if value, err := ec.Get(); err != nil || *value != 2 {
+		t.Errorf("expected 2 mS/cm at the water temperature read, got %v %v", value, err)
+	}
+}
@@ -1,11 +1,12 @@
 package control
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
 )
 
 // GrowLight struct
@@ -21,37 +22,89 @@ func NewGrowLight() (GrowLight, error) {
 }
 
 // WaitThenTurnOn waits for the amount of time set in the config file "every" to pass then the
-// light is turned on. The light stays on according the at amount of time set "onTime"
-func (gl GrowLight) WaitThenTurnOn() {
-	growLight := OutputDevice(gl)
+// light is turned on. The light stays on according the at amount of time set "onTime". It runs
+// until ctx is done.
+func (gl GrowLight) WaitThenTurnOn(ctx context.Context) error {
+	growLight := OutputDevice(gl).With(ReasonSchedule)
 	for {
-		timer := time.NewTimer(time.Minute * time.Duration(growLight.Every))
-		defer timer.Stop()
-		// wait for the timer to reach its limit
-		<-timer.C
+		if err := sleep(ctx, time.Minute*time.Duration(growLight.Every)); err != nil {
+			return err
+		}
 		if err := growLight.On(); err != nil {
-			fmt.Println(err)
+			return err
+		}
+		if err := sleep(ctx, time.Minute*time.Duration(growLight.OnTime)); err != nil {
+			return err
+		}
+		if err := growLight.Off(); err != nil {
+			return err
 		}
-		time.Sleep(time.Minute * time.Duration(growLight.OnTime))
-		growLight.Off()
 	}
 }
 
 // TurnOnThenWait turns the light on for the anount of time set in the config file "onTime"
-// The light is then turned off for the amount of time set in the config file "every"
-func (gl GrowLight) TurnOnThenWait() {
-	fmt.Println("called the light function")
-	growLight := OutputDevice(gl)
+// The light is then turned off for the amount of time set in the config file "every". It runs
+// until ctx is done.
+func (gl GrowLight) TurnOnThenWait(ctx context.Context) error {
+	growLight := OutputDevice(gl).With(ReasonSchedule)
+	for {
+		if err := growLight.On(); err != nil {
+			return err
+		}
+		if err := sleep(ctx, time.Minute*time.Duration(growLight.OnTime)); err != nil {
+			return err
+		}
+		if err := growLight.Off(); err != nil {
+			return err
+		}
+		if err := sleep(ctx, time.Minute*time.Duration(growLight.Every)); err != nil {
+			return err
+		}
+	}
+}
+
+// FollowPhotoperiod keeps the light on or off according to the time of day set in the photoperiod.
+// The state is checked every minute so after a restart the light goes straight to the state it
+// should be in. Every change is sent over the entry channel. It runs until ctx is done.
+func (gl GrowLight) FollowPhotoperiod(ctx context.Context, p *Photoperiod, entry chan *types.LogEntry) error {
+	growLight := OutputDevice(gl).With(ReasonSchedule)
+	var isOn *bool
 	for {
-		growLight.On()
-		time.Sleep(time.Minute * time.Duration(growLight.OnTime))
-		if err := gl.Off(); err != nil {
-			log.Println(err)
+		now := time.Now()
+		on, err := p.IsOn(now)
+		if err != nil {
+			return permanent(fmt.Errorf("cannot read the photoperiod %v", err))
+		}
+		if isOn == nil || *isOn != on {
+			action, switchLight := "off", growLight.Off
+			if on {
+				action, switchLight = "on", growLight.On
+			}
+			message := fmt.Sprintf("Grow light turned %s at %s", action, now.Format(clockLayout))
+			if stage := p.Stage(now); stage != nil {
+				message = fmt.Sprintf("%s for the %s stage (%s - %s)", message, stage.Name, stage.On, stage.Off)
+			}
+			if err := switchLight(); err != nil {
+				entry <- &types.LogEntry{
+					Message: fmt.Sprintf("Something went wrong turning the grow light %s %v", action, err),
+					Success: false,
+					Time:    now.Unix(),
+					Type:    string(consts.GrowLight),
+				}
+			} else {
+				isOn = &on
+				entry <- &types.LogEntry{
+					Message: message,
+					Success: true,
+					Time:    now.Unix(),
+					Type:    string(consts.GrowLight),
+				}
+			}
+		}
+		// wake up at the start of the next minute.
+		if err := sleep(ctx, now.Truncate(time.Minute).Add(time.Minute).Sub(time.Now())); err != nil {
+			return err
 		}
-		timer := time.NewTimer(time.Minute * time.Duration(growLight.Every))
-		defer timer.Stop()
-		// wait for the timer to reach its limit
-		<-timer.C
 	}
 }
 
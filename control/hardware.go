@@ -0,0 +1,117 @@
+package control
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+)
+
+// Hardware is the backend the control package talks to. Every pin, bus and ADC access goes
+// through it so the controller can run against a Raspberry Pi or a simulation.
+type Hardware interface {
+	// OpenGPIO gives access to the GPIO header. The returned GPIO must be closed when done.
+	OpenGPIO() (GPIO, error)
+	// OpenI2C opens a connection to the device at address on the given bus.
+	OpenI2C(address uint8, bus int) (I2CDevice, error)
+	// OpenADC opens the analog-to-digital converter at address on the given bus.
+	OpenADC(address, bus int) (ADC, error)
+}
+
+// GPIO is an open handle to the GPIO header.
+type GPIO interface {
+	Pin(number uint8) Pin
+	StartPwm()
+	StopPwm()
+	Close() error
+}
+
+// Pin is a single GPIO pin.
+type Pin interface {
+	Output()
+	Pwm()
+	High()
+	Low()
+	Freq(freq int)
+	DutyCycle(dutyLen, cycleLen uint32)
+	Input()
+	PullUp()
+	// Read returns the level of an input pin, true when it is high.
+	Read() bool
+}
+
+// I2CDevice is an open connection to a single device on an I2C bus.
+type I2CDevice interface {
+	WriteBytes(buf []byte) (int, error)
+	ReadBytes(buf []byte) (int, error)
+	Close() error
+}
+
+// ADC is an analog-to-digital converter with several input channels such as the ADS1115.
+type ADC interface {
+	Read(channel int) (float64, error)
+	Close() error
+}
+
+// HardwareSetting is the hardware section of the config file.
+type HardwareSetting struct {
+	Backend consts.HardwareBackend `yaml:"backend"`
+}
+
+type hardwareConfig struct {
+	Hardware HardwareSetting `yaml:"hardware"`
+}
+
+var (
+	hardwareMu sync.Mutex
+	hardware   Hardware
+)
+
+// NewHardware reads the hardware section of the config file and returns the backend set there.
+// The Raspberry Pi is used when no backend is set.
+func NewHardware() (Hardware, error) {
+	var setting hardwareConfig
+
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	switch consts.HardwareBackend(strings.ToLower(string(setting.Hardware.Backend))) {
+	case consts.RaspberryPi, "":
+		return RaspberryPi{}, nil
+	case consts.Simulated:
+		return NewSimulatedHardware(), nil
+	}
+	return nil, fmt.Errorf("unknown hardware backend %s", setting.Hardware.Backend)
+}
+
+// UseHardware sets the backend used by every device and sensor in the package.
+func UseHardware(h Hardware) {
+	hardwareMu.Lock()
+	defer hardwareMu.Unlock()
+	hardware = h
+}
+
+// CurrentHardware returns the backend in use. If none was set using UseHardware the backend
+// is read from the config file.
+func CurrentHardware() Hardware {
+	hardwareMu.Lock()
+	defer hardwareMu.Unlock()
+	if hardware == nil {
+		h, err := NewHardware()
+		if err != nil {
+			log.Printf("cannot read the hardware backend, using the raspberry pi. %v", err)
+			h = RaspberryPi{}
+		}
+		hardware = h
+	}
+	return hardware
+}
@@ -0,0 +1,81 @@
+package control
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimulatedSHT3x(t *testing.T) {
+	hw := NewSimulatedHardware()
+	hw.Attach(0x45, 1, NewSimulatedSHT3x(27.3, 61.5))
+
+	device, err := hw.OpenI2C(0x45, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer device.Close()
+
+	temperature, humidity, err := readSHT3x(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(temperature-27.3) > 0.01 || math.Abs(humidity-61.5) > 0.01 {
+		t.Errorf("got %v c and %v %%, expected 27.3 c and 61.5 %%", temperature, humidity)
+	}
+	if _, err := hw.OpenI2C(0x50, 1); err == nil {
+		t.Error("expected an error opening a device that is not attached")
+	}
+}
+
+func TestSimulatedOutputDevice(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+
+	fan := OutputDevice{Pins: DriverPins{EN: 21, IN1: 20, IN2: 16}, Rate: 0.5}
+	if err := fan.On(); err != nil {
+		t.Fatal(err)
+	}
+	if state := hw.PinState(21); state.Mode != "pwm" || state.DutyCycle != 0.5 {
+		t.Errorf("expected the enable pin at a 0.5 duty cycle, got %+v", state)
+	}
+	if !hw.PinState(20).High || hw.PinState(16).High {
+		t.Error("expected in1 high and in2 low")
+	}
+	if err := fan.Off(); err != nil {
+		t.Fatal(err)
+	}
+	if state := hw.PinState(21); state.DutyCycle != 0 || state.High {
+		t.Errorf("expected the enable pin low, got %+v", state)
+	}
+}
+
+func TestSimulatedCO2AndLightSensors(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+
+	if crc := sht3xCRC([]byte{0x00, 0x00}); crc != scd30StartMeasurement[4] {
+		t.Errorf("expected the start measurement crc to be %#x, got %#x", crc, scd30StartMeasurement[4])
+	}
+
+	hw.Attach(0x61, 1, NewSimulatedSCD30(812))
+	co2 := &CO2Sensor{Address: 0x61, Bus: 1}
+	value, err := co2.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *value != 812 {
+		t.Errorf("expected 812 ppm, got %v", *value)
+	}
+
+	hw.Attach(0x23, 1, NewSimulatedBH1750(18500))
+	light := &LightSensor{Address: 0x23, Bus: 1}
+	lux, err := light.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *lux != 18500 {
+		t.Errorf("expected 18500 lux, got %v", *lux)
+	}
+}
@@ -0,0 +1,202 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+// HeaterControl is the heaterControl section of the config file.
+type HeaterControl struct {
+	Setpoint     float64 `yaml:"setpoint"`     // c, the heater turns on below it.
+	Hysteresis   float64 `yaml:"hysteresis"`   // c above the setpoint the heater turns off at.
+	MaxDutyCycle float64 `yaml:"maxDutyCycle"` // most of every window the heater may run, 0 - 1.
+	Window       int64   `yaml:"window"`       // minutes.
+	Every        int64   `yaml:"every"`        // seconds between updates.
+}
+
+type heaterControlConfig struct {
+	HeaterControl *HeaterControl `yaml:"heaterControl"`
+}
+
+// Heater keeps the temperature above the setpoint of the heaterControl setting. The heater stops
+// below the setpoint of the temperature control, so the two never work against each other, and
+// it is not turned on while the cooling fan runs. The cooling fan in turn stays off while the
+// heater runs. The setpoint follows the setpoint profiles.
+type Heater struct {
+	setting  HeaterControl
+	profiles *SetpointProfiles // nil without profiles.
+	sensor   Sensor
+	heater   *OutputDevice
+	fan      *OutputDevice // nil without a cooling fan.
+
+	heating     bool
+	blocked     bool // the heater was kept off for the fan.
+	resting     bool // the heater reached its duty cycle in this window.
+	started     time.Time
+	from        float64 // the temperature the heating started at.
+	last        time.Time
+	windowStart time.Time
+	used        time.Duration // the heater ran this long in the window.
+}
+
+// NewHeater reads the heaterControl section of the config file. It returns nil when the section
+// is not set.
+func NewHeater(sensors *Registry, devices *DeviceManager) (*Heater, error) {
+	var setting heaterControlConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	if setting.HeaterControl == nil {
+		return nil, nil
+	}
+	temperatureControl, err := NewTemperatureControl()
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := NewSetpointProfiles()
+	if err != nil {
+		return nil, err
+	}
+	return newHeater(*setting.HeaterControl, temperatureControl.Setpoint, profiles, sensors, devices)
+}
+
+func newHeater(setting HeaterControl, coolingSetpoint float64, profiles *SetpointProfiles, sensors *Registry, devices *DeviceManager) (*Heater, error) {
+	if setting.Hysteresis <= 0 {
+		return nil, fmt.Errorf("heaterControl needs a hysteresis greater than 0")
+	}
+	for _, sp := range profiles.each(climateSetpoints{temperature: coolingSetpoint, heating: setting.Setpoint}) {
+		if sp.heating+setting.Hysteresis >= sp.temperature {
+			return nil, fmt.Errorf("the heater has to stop below the temperatureControl setpoint of %vc, it would run against the %s", sp.temperature, consts.CoolingFan)
+		}
+	}
+	if setting.MaxDutyCycle <= 0 || setting.MaxDutyCycle > 1 {
+		return nil, fmt.Errorf("heaterControl needs a maxDutyCycle between 0 and 1")
+	}
+	if setting.Window <= 0 || setting.Every <= 0 {
+		return nil, fmt.Errorf("heaterControl needs a window and an every greater than 0")
+	}
+	sensor, ok := sensors.find(string(consts.Temperature))
+	if !ok {
+		return nil, fmt.Errorf("heaterControl needs a temperature sensor")
+	}
+	heater, err := devices.Device(consts.Heater)
+	if err != nil {
+		return nil, err
+	}
+	if !heater.Automatic {
+		return nil, fmt.Errorf("%s needs to be automatic to be run by the heater control", heater.Name)
+	}
+	h := &Heater{setting: setting, profiles: profiles, sensor: sensor, heater: heater}
+	if fan, err := devices.Device(consts.CoolingFan); err == nil {
+		h.fan = fan
+	}
+	return h, nil
+}
+
+// Run updates the heater every few seconds, as set by every, until ctx is done. The start and
+// the end of every heating period are sent over the entry channel.
+func (h *Heater) Run(ctx context.Context, entry chan *types.LogEntry) error {
+	ticker := time.NewTicker(time.Second * time.Duration(h.setting.Every))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		for _, logEntry := range h.step(time.Now()) {
+			entry <- logEntry
+		}
+	}
+}
+
+// step reads the temperature and switches the heater.
+func (h *Heater) step(now time.Time) []*types.LogEntry {
+	var entries []*types.LogEntry
+	// the safety or a rule may have turned the heater off since the last step, it only ran until
+	// then.
+	if state, _ := tracker.state(h.heater.Name, now); h.heating && !state.On {
+		off := time.Unix(state.Since, 0)
+		if off.After(h.last) {
+			h.used += off.Sub(h.last)
+		}
+		h.heating = false
+		entries = append(entries, h.entry(fmt.Sprintf("%s was turned off (%s). It heated for %v from %vc.", h.heater.Name, state.Reason, off.Sub(h.started).Round(time.Second), h.from), true, now))
+	}
+	if h.heating {
+		h.used += now.Sub(h.last)
+	}
+	h.last = now
+	window := time.Minute * time.Duration(h.setting.Window)
+	if now.Sub(h.windowStart) >= window {
+		h.windowStart, h.used, h.resting = now, 0, false
+	}
+
+	value, err := h.sensor.Read()
+	if err != nil {
+		entries = append(entries, h.entry(fmt.Sprintf("Something went wrong reading the temperature %v", err), false, now))
+		// the heater is not left running blind.
+		if h.heating {
+			entries = append(entries, h.stop(now, "as the temperature cannot be read"))
+		}
+		return entries
+	}
+	s := h.setting
+	s.Setpoint = h.profiles.at(now, climateSetpoints{heating: s.Setpoint}).heating
+	maxOn := time.Duration(s.MaxDutyCycle * float64(window))
+	switch {
+	case h.heating && value >= s.Setpoint+s.Hysteresis:
+		return append(entries, h.stop(now, fmt.Sprintf("at %vc", value)))
+	case h.heating && h.used >= maxOn:
+		h.resting = true
+		return append(entries, h.stop(now, fmt.Sprintf("at %vc, it ran for its %v of the last %v", value, maxOn, window)))
+	case h.heating || h.resting || value >= s.Setpoint:
+		h.blocked = false
+		return entries
+	}
+
+	if h.fan != nil {
+		if state, _ := tracker.state(h.fan.Name, now); state.On {
+			if h.blocked {
+				return entries
+			}
+			h.blocked = true
+			return append(entries, h.entry(fmt.Sprintf("Temperature is %vc, below the heating setpoint of %vc. Kept %s off while %s runs.", value, s.Setpoint, h.heater.Name, h.fan.Name), true, now))
+		}
+	}
+	h.blocked = false
+	if err := h.heater.With(ReasonControl).On(); err != nil {
+		return append(entries, h.entry(fmt.Sprintf("Something went wrong turning %s on %v", h.heater.Name, err), false, now))
+	}
+	h.heating, h.started, h.from = true, now, value
+	return append(entries, h.entry(fmt.Sprintf("Temperature is %vc, below the heating setpoint of %vc. Turned %s on.", value, s.Setpoint, h.heater.Name), true, now))
+}
+
+// stop turns the heater off and returns the heating period.
+func (h *Heater) stop(now time.Time, why string) *types.LogEntry {
+	if err := h.heater.With(ReasonControl).Off(); err != nil {
+		return h.entry(fmt.Sprintf("Something went wrong turning %s off %v", h.heater.Name, err), false, now)
+	}
+	h.heating = false
+	return h.entry(fmt.Sprintf("Turned %s off %s. It heated for %v from %vc.", h.heater.Name, why, now.Sub(h.started).Round(time.Second), h.from), true, now)
+}
+
+func (h *Heater) entry(message string, success bool, now time.Time) *types.LogEntry {
+	return &types.LogEntry{
+		Message: message,
+		Success: success,
+		Time:    now.Unix(),
+		Type:    string(consts.Heater),
+	}
+}
@@ -0,0 +1,144 @@
+package control
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+func TestHeater(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	saved := tracker
+	tracker = &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	defer func() { tracker = saved }()
+
+	temperature := 20.0
+	sensors := &Registry{}
+	sensors.add(&sensor{name: "temperature", kind: consts.Temperature, read: func() (*float64, error) { return &temperature, nil }})
+	devices, err := newDeviceManager([]OutputDevice{
+		{Name: consts.Heater, Pin: 23, Rate: 1, Automatic: true},
+		{Name: consts.CoolingFan, Pins: DriverPins{EN: 22, IN1: 27, IN2: 17}, Rate: 1, Automatic: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := newHeater(HeaterControl{Setpoint: 18, Hysteresis: 1.5, MaxDutyCycle: 0.5, Window: 60, Every: 30}, 28, nil, sensors, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fan, _ := devices.Device(consts.CoolingFan)
+	start := time.Now()
+
+	if entries := h.step(start); len(entries) != 0 || hw.PinState(23).High {
+		t.Fatalf("expected the heater off above the setpoint, got %v", entries)
+	}
+	// the fan still runs, the heater waits for it.
+	if err := fan.With(ReasonControl).On(); err != nil {
+		t.Fatal(err)
+	}
+	temperature = 17
+	entries := h.step(start.Add(time.Minute))
+	if len(entries) != 1 || !strings.Contains(entries[0].Message, "Kept heater off while coolingFan runs") || hw.PinState(23).High {
+		t.Fatalf("expected the heater kept off while the fan runs, got %v", entries)
+	}
+	if entries := h.step(start.Add(2 * time.Minute)); len(entries) != 0 {
+		t.Errorf("expected the fan logged once, got %v", entries)
+	}
+	if err := fan.With(ReasonControl).Off(); err != nil {
+		t.Fatal(err)
+	}
+	if entries := h.step(start.Add(3 * time.Minute)); len(entries) != 1 || !hw.PinState(23).High {
+		t.Fatalf("expected the heater on below the setpoint, got %v", entries)
+	}
+
+	// inside the hysteresis the heater keeps running.
+	temperature = 19
+	if entries := h.step(start.Add(10 * time.Minute)); len(entries) != 0 || !hw.PinState(23).High {
+		t.Errorf("expected the heater on inside the hysteresis, got %v", entries)
+	}
+	temperature = 19.5
+	entries = h.step(start.Add(13 * time.Minute))
+	if len(entries) != 1 || !strings.Contains(entries[0].Message, "It heated for 10m0s from 17c") || hw.PinState(23).High {
+		t.Fatalf("expected the heating period logged once the heater is off, got %v", entries)
+	}
+
+	// 10 of the 30 minutes of the window are used, the heater rests after 20 more.
+	temperature = 15
+	h.step(start.Add(20 * time.Minute))
+	entries = h.step(start.Add(40 * time.Minute))
+	if len(entries) != 1 || !strings.Contains(entries[0].Message, "it ran for its 30m0s of the last 1h0m0s") || hw.PinState(23).High {
+		t.Fatalf("expected the heater off at its duty cycle, got %v", entries)
+	}
+	if entries := h.step(start.Add(50 * time.Minute)); len(entries) != 0 || hw.PinState(23).High {
+		t.Errorf("expected the heater to rest until the window ends, got %v", entries)
+	}
+	if entries := h.step(start.Add(61 * time.Minute)); len(entries) != 1 || !hw.PinState(23).High {
+		t.Errorf("expected the heater on in the next window, got %v", entries)
+	}
+}
+
+func TestHeaterSetting(t *testing.T) {
+	sensors := &Registry{}
+	sensors.add(&sensor{name: "temperature", kind: consts.Temperature})
+	devices, err := newDeviceManager([]OutputDevice{{Name: consts.Heater, Pin: 23, Rate: 1, Automatic: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, setting := range []HeaterControl{
+		{Setpoint: 18, MaxDutyCycle: 0.5, Window: 60, Every: 30},
+		// the heater would still run at the setpoint of the fan.
+		{Setpoint: 27, Hysteresis: 1, MaxDutyCycle: 0.5, Window: 60, Every: 30},
+		{Setpoint: 18, Hysteresis: 1, MaxDutyCycle: 1.5, Window: 60, Every: 30},
+		{Setpoint: 18, Hysteresis: 1, MaxDutyCycle: 0.5, Every: 30},
+	} {
+		if _, err := newHeater(setting, 28, nil, sensors, devices); err == nil {
+			t.Errorf("expected an error for %+v", setting)
+		}
+	}
+}
+
+func TestHeaterForcedOff(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	saved := tracker
+	tracker = &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	defer func() { tracker = saved }()
+
+	temperature := 15.0
+	sensors := &Registry{}
+	sensors.add(&sensor{name: "temperature", kind: consts.Temperature, read: func() (*float64, error) { return &temperature, nil }})
+	devices, err := newDeviceManager([]OutputDevice{
+		{Name: consts.Heater, Pin: 23, Rate: 1, Automatic: true},
+		{Name: consts.CoolingFan, Pins: DriverPins{EN: 22, IN1: 27, IN2: 17}, Rate: 1, Automatic: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := newHeater(HeaterControl{Setpoint: 18, Hysteresis: 1.5, MaxDutyCycle: 0.5, Window: 60, Every: 30}, 28, nil, sensors, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if entries := h.step(start); len(entries) != 1 || !hw.PinState(23).High {
+		t.Fatalf("expected the heater on, got %v", entries)
+	}
+	heater, _ := devices.Device(consts.Heater)
+	if err := heater.With(ReasonSafety).Off(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the heater is taken as off and turned back on, without the time it was off.
+	entries := h.step(start.Add(time.Minute))
+	if len(entries) != 2 || !strings.Contains(entries[0].Message, "was turned off (safety)") || !hw.PinState(23).High {
+		t.Fatalf("expected the forced off logged and the heater back on, got %v", entries)
+	}
+	if h.used > time.Second {
+		t.Errorf("expected no duty time counted while the heater was off, got %v", h.used)
+	}
+}
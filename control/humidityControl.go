@@ -0,0 +1,231 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+// HumidityControl is the humidityControl section of the config file.
+type HumidityControl struct {
+	Low             float64             `yaml:"low"`  // %, the humidifier runs below it.
+	High            float64             `yaml:"high"` // %, the dehumidifier and the air exchange run above it.
+	Deadband        float64             `yaml:"deadband"`
+	MinCycleTime    int64               `yaml:"minCycleTime"` // minutes a humidifier or dehumidifier stays on or off at least.
+	Every           int64               `yaml:"every"`        // seconds between updates.
+	AirExchange     consts.OutputDevice `yaml:"airExchange"`  // airpump or coolingFan, left out to not exchange air.
+	AirExchangeRate float64             `yaml:"airExchangeRate"`
+}
+
+type humidityControlConfig struct {
+	HumidityControl *HumidityControl `yaml:"humidityControl"`
+}
+
+// Humidistat keeps the humidity inside the band of the humidityControl setting using the
+// humidifier, the dehumidifier and a device bringing in outside air. Each runs until the humidity
+// is deadband back inside the band. The air exchange is not used while the temperature is below
+// the setpoint of the temperature control, the outside air would cool the room further, and the
+// cooling fan is shared with the temperature control through the highest rate the two ask for.
+// The band and the setpoint follow the setpoint profiles.
+type Humidistat struct {
+	setting      HumidityControl
+	humidity     Sensor
+	temperature  Sensor // nil without a temperature sensor.
+	setpoint     float64
+	profiles     *SetpointProfiles // nil without profiles.
+	current      climateSetpoints  // the band and the setpoint of the last step.
+	humidifier   *OutputDevice
+	dehumidifier *OutputDevice
+	air          *OutputDevice
+
+	wetting, drying bool // the humidity is below or above the band.
+	exchanging      bool
+	tooCold         bool
+}
+
+// NewHumidistat reads the humidityControl section of the config file. It returns nil when the
+// section is not set.
+func NewHumidistat(sensors *Registry, devices *DeviceManager) (*Humidistat, error) {
+	var setting humidityControlConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	if setting.HumidityControl == nil {
+		return nil, nil
+	}
+	temperatureControl, err := NewTemperatureControl()
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := NewSetpointProfiles()
+	if err != nil {
+		return nil, err
+	}
+	return newHumidistat(*setting.HumidityControl, temperatureControl.Setpoint, profiles, sensors, devices)
+}
+
+func newHumidistat(setting HumidityControl, setpoint float64, profiles *SetpointProfiles, sensors *Registry, devices *DeviceManager) (*Humidistat, error) {
+	for _, sp := range profiles.each(climateSetpoints{humidityLow: setting.Low, humidityHigh: setting.High}) {
+		if sp.humidityLow <= 0 || sp.humidityHigh <= sp.humidityLow || sp.humidityHigh > 100 {
+			return nil, fmt.Errorf("humidityControl needs a low value below the high value, both between 0 and 100")
+		}
+		if setting.Deadband < 0 || setting.Deadband >= sp.humidityHigh-sp.humidityLow {
+			return nil, fmt.Errorf("humidityControl needs a deadband of 0 or more, smaller than the band")
+		}
+	}
+	if setting.MinCycleTime < 0 || setting.Every <= 0 {
+		return nil, fmt.Errorf("humidityControl needs a minCycleTime of 0 or more and an every greater than 0")
+	}
+	humidity, ok := sensors.find(string(consts.Humidity))
+	if !ok {
+		return nil, fmt.Errorf("humidityControl needs a humidity sensor")
+	}
+	h := &Humidistat{setting: setting, humidity: humidity, setpoint: setpoint, profiles: profiles}
+	if temperature, ok := sensors.find(string(consts.Temperature)); ok {
+		h.temperature = temperature
+	}
+	for _, d := range []struct {
+		name   consts.OutputDevice
+		device **OutputDevice
+	}{{consts.Humidifier, &h.humidifier}, {consts.Dehumidifier, &h.dehumidifier}} {
+		device, err := devices.Device(d.name)
+		if err != nil {
+			continue
+		}
+		if !device.Automatic {
+			return nil, fmt.Errorf("%s needs to be automatic to be run by the humidity control", device.Name)
+		}
+		*d.device = device
+	}
+	if setting.AirExchange != "" {
+		air, err := devices.Device(setting.AirExchange)
+		if err != nil {
+			return nil, err
+		}
+		if setting.AirExchangeRate <= 0 || setting.AirExchangeRate > 1 {
+			return nil, fmt.Errorf("humidityControl needs an airExchangeRate between 0 and 1")
+		}
+		h.air = air
+	}
+	if h.humidifier == nil && h.dehumidifier == nil && h.air == nil {
+		return nil, fmt.Errorf("humidityControl needs a %s, a %s or an airExchange device", consts.Humidifier, consts.Dehumidifier)
+	}
+	return h, nil
+}
+
+// Run updates the devices every few seconds, as set by every, until ctx is done. Every switch
+// is sent over the entry channel.
+func (h *Humidistat) Run(ctx context.Context, entry chan *types.LogEntry) error {
+	ticker := time.NewTicker(time.Second * time.Duration(h.setting.Every))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		for _, logEntry := range h.step(time.Now()) {
+			entry <- logEntry
+		}
+	}
+}
+
+// step reads the humidity and switches the devices.
+func (h *Humidistat) step(now time.Time) []*types.LogEntry {
+	value, err := h.humidity.Read()
+	if err != nil {
+		return []*types.LogEntry{h.entry(fmt.Sprintf("Something went wrong reading the humidity %v", err), false, now)}
+	}
+	h.current = h.profiles.at(now, climateSetpoints{temperature: h.setpoint, humidityLow: h.setting.Low, humidityHigh: h.setting.High})
+	low, high, deadband := h.current.humidityLow, h.current.humidityHigh, h.setting.Deadband
+	h.wetting = value < low || h.wetting && value < low+deadband
+	h.drying = value > high || h.drying && value > high-deadband
+
+	var entries []*types.LogEntry
+	for _, d := range []struct {
+		device *OutputDevice
+		on     bool
+	}{{h.humidifier, h.wetting}, {h.dehumidifier, h.drying}} {
+		if d.device == nil {
+			continue
+		}
+		if logEntry := h.switchDevice(d.device, d.on, value, now); logEntry != nil {
+			entries = append(entries, logEntry)
+		}
+	}
+	if h.air != nil {
+		if logEntry := h.exchangeAir(value, now); logEntry != nil {
+			entries = append(entries, logEntry)
+		}
+	}
+	return entries
+}
+
+// switchDevice turns the device on or off unless it was switched less than minCycleTime ago.
+func (h *Humidistat) switchDevice(device *OutputDevice, on bool, value float64, now time.Time) *types.LogEntry {
+	state, _ := tracker.state(device.Name, now)
+	if state.On == on || now.Unix()-state.Since < h.setting.MinCycleTime*60 {
+		return nil
+	}
+	d := device.With(ReasonControl)
+	action, switchDevice := "off", d.Off
+	if on {
+		action, switchDevice = "on", d.On
+	}
+	if err := switchDevice(); err != nil {
+		return h.entry(fmt.Sprintf("Something went wrong turning %s %s %v", device.Name, action, err), false, now)
+	}
+	return h.entry(fmt.Sprintf("Humidity is %v%%, the band is %v - %v%%. Turned %s %s.", value, h.current.humidityLow, h.current.humidityHigh, device.Name, action), true, now)
+}
+
+// exchangeAir runs the air exchange while the humidity is above the band and the room is not
+// below the temperature setpoint.
+func (h *Humidistat) exchangeAir(value float64, now time.Time) *types.LogEntry {
+	tooCold := false
+	var temperature float64
+	if h.drying && h.temperature != nil {
+		// without a temperature the air is exchanged anyway, the temperature control sees it.
+		if t, err := h.temperature.Read(); err == nil {
+			temperature, tooCold = t, t < h.current.temperature
+		}
+	}
+	rate := 0.0
+	if h.drying && !tooCold {
+		rate = h.setting.AirExchangeRate
+	}
+	applied, _, err := demands.request(h.air.With(ReasonControl), "humidity", rate)
+	if err != nil {
+		return h.entry(fmt.Sprintf("Something went wrong running %s for the air exchange %v", h.air.Name, err), false, now)
+	}
+
+	exchanging := rate > 0
+	defer func() { h.exchanging, h.tooCold = exchanging, tooCold }()
+	switch {
+	case tooCold && !h.tooCold:
+		return h.entry(fmt.Sprintf("Humidity is %v%%, above %v%%, but the temperature of %vc is below the setpoint of %vc. The air is not exchanged through %s.", value, h.current.humidityHigh, temperature, h.current.temperature, h.air.Name), true, now)
+	case exchanging && !h.exchanging:
+		return h.entry(fmt.Sprintf("Humidity is %v%%, above %v%%. Running %s at %.2f to exchange the air.", value, h.current.humidityHigh, h.air.Name, applied), true, now)
+	case !exchanging && h.exchanging:
+		return h.entry(fmt.Sprintf("Humidity is %v%%. Stopped the air exchange through %s.", value, h.air.Name), true, now)
+	}
+	return nil
+}
+
+func (h *Humidistat) entry(message string, success bool, now time.Time) *types.LogEntry {
+	return &types.LogEntry{
+		Message: message,
+		Success: success,
+		Time:    now.Unix(),
+		Type:    string(consts.Humidity),
+	}
+}
@@ -0,0 +1,110 @@
+package control
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+func TestHumidistat(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	saved, savedDemands := tracker, demands
+	tracker = &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	demands = &demand{rates: map[consts.OutputDevice]map[string]float64{}}
+	defer func() { tracker, demands = saved, savedDemands }()
+
+	humidity, temperature := 60.0, 25.0
+	sensors := &Registry{}
+	sensors.add(&sensor{name: "humidity", kind: consts.Humidity, read: func() (*float64, error) { return &humidity, nil }})
+	sensors.add(&sensor{name: "temperature", kind: consts.Temperature, read: func() (*float64, error) { return &temperature, nil }})
+	devices, err := newDeviceManager([]OutputDevice{
+		{Name: consts.Humidifier, Pin: 24, Rate: 1, Automatic: true},
+		{Name: consts.Dehumidifier, Pin: 26, Rate: 1, Automatic: true},
+		{Name: consts.CoolingFan, Pins: DriverPins{EN: 22, IN1: 27, IN2: 17}, Rate: 1, Automatic: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting := HumidityControl{Low: 55, High: 75, Deadband: 3, MinCycleTime: 5, Every: 60, AirExchange: consts.CoolingFan, AirExchangeRate: 0.6}
+	h, err := newHumidistat(setting, 22, nil, sensors, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	humidity = 50
+	if entries := h.step(now); len(entries) != 1 || !strings.Contains(entries[0].Message, "Turned humidifier on") {
+		t.Fatalf("expected the humidifier on below the band, got %v", entries)
+	}
+	// inside the deadband the humidifier keeps running.
+	humidity = 56
+	if entries := h.step(now.Add(6 * time.Minute)); len(entries) != 0 || !hw.PinState(24).High {
+		t.Errorf("expected the humidifier on inside the deadband, got %v", entries)
+	}
+	// the humidity is back but the humidifier has not run for minCycleTime yet.
+	humidity = 60
+	if entries := h.step(now.Add(time.Minute)); len(entries) != 0 || !hw.PinState(24).High {
+		t.Errorf("expected the humidifier on for minCycleTime, got %v", entries)
+	}
+	if entries := h.step(now.Add(6 * time.Minute)); len(entries) != 1 || hw.PinState(24).High {
+		t.Errorf("expected the humidifier off after minCycleTime, got %v", entries)
+	}
+
+	// above the band the temperature control already runs the fan faster.
+	fan, err := devices.Device(consts.CoolingFan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := demands.request(fan.With(ReasonControl), "temperature", 0.8); err != nil {
+		t.Fatal(err)
+	}
+	humidity = 80
+	entries := h.step(now.Add(12 * time.Minute))
+	if len(entries) != 2 || !strings.Contains(entries[1].Message, "at 0.80") || !hw.PinState(26).High {
+		t.Errorf("expected the dehumidifier on and the fan kept at the temperature rate, got %v", entries)
+	}
+	if _, _, err := demands.request(fan.With(ReasonControl), "temperature", 0); err != nil {
+		t.Fatal(err)
+	}
+	// the duty cycle is in 128 steps.
+	if state := hw.PinState(22); math.Abs(state.DutyCycle-0.6) > 1.0/128 {
+		t.Errorf("expected the fan at the air exchange rate once the temperature control stops, got %v", state.DutyCycle)
+	}
+
+	// below the setpoint the outside air would cool the room.
+	temperature = 20
+	entries = h.step(now.Add(13 * time.Minute))
+	if len(entries) != 1 || !strings.Contains(entries[0].Message, "below the setpoint") || hw.PinState(22).DutyCycle != 0 {
+		t.Errorf("expected no air exchange below the setpoint, got %v", entries)
+	}
+}
+
+func TestHumidistatSetting(t *testing.T) {
+	sensors := &Registry{}
+	sensors.add(&sensor{name: "humidity", kind: consts.Humidity})
+	devices, err := newDeviceManager([]OutputDevice{
+		{Name: consts.Humidifier, Pin: 24, Rate: 1},
+		{Name: consts.AirPump, Pin: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, setting := range []HumidityControl{
+		{Low: 75, High: 55, Every: 60},
+		{Low: 55, High: 75, Deadband: 20, Every: 60},
+		{Low: 55, High: 75, AirExchange: consts.AirPump, AirExchangeRate: 1},
+		{Low: 55, High: 75, Every: 60, AirExchange: consts.AirPump},
+		// the humidifier is not automatic.
+		{Low: 55, High: 75, Every: 60, AirExchange: consts.AirPump, AirExchangeRate: 1},
+	} {
+		if _, err := newHumidistat(setting, 22, nil, sensors, devices); err == nil {
+			t.Errorf("expected an error for %+v", setting)
+		}
+	}
+}
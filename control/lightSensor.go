@@ -0,0 +1,78 @@
+package control
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+)
+
+// LightSensor is a BH1750 ambient light sensor. Readings are in lux.
+type LightSensor I2CSensor
+
+// one time measurement at a resolution of 1 lux. The sensor powers down afterwards.
+var bh1750OneTimeHighResolution = []byte{0x20}
+
+// NewLightSensor returns the bh1750 from the i2cSensors setting.
+func NewLightSensor() (*LightSensor, error) {
+	lightSensor, err := NewI2CSensor(consts.BH1750)
+	if err != nil {
+		return nil, err
+	}
+	ls := LightSensor(*lightSensor)
+	return &ls, nil
+}
+
+// Get measures the illuminance.
+func (l *LightSensor) Get() (*float64, error) {
+	i2cconn, err := CurrentHardware().OpenI2C(l.Address, l.Bus)
+	if err != nil {
+		return nil, err
+	}
+	defer i2cconn.Close()
+
+	if _, err := i2cconn.WriteBytes(bh1750OneTimeHighResolution); err != nil {
+		return nil, err
+	}
+	// a high resolution measurement takes up to 180ms.
+	time.Sleep(180 * time.Millisecond)
+	buf := make([]byte, 2)
+	if _, err := i2cconn.ReadBytes(buf); err != nil {
+		return nil, err
+	}
+	lux := new(float64)
+	*lux = ToFixed(float64(uint16(buf[0])<<8|uint16(buf[1]))/1.2, 0)
+	return lux, nil
+}
+
+// SimulatedBH1750 answers measurements with the illuminance it was last set to.
+type SimulatedBH1750 struct {
+	mu  sync.Mutex
+	lux float64
+}
+
+// NewSimulatedBH1750 returns a simulated BH1750 reading the illuminance (lux) given.
+func NewSimulatedBH1750(lux float64) *SimulatedBH1750 {
+	return &SimulatedBH1750{lux: lux}
+}
+
+// Set changes the illuminance (lux) returned by the next measurement.
+func (s *SimulatedBH1750) Set(lux float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lux = lux
+}
+
+// WriteBytes accepts any command. Only measurements are simulated.
+func (s *SimulatedBH1750) WriteBytes(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+// ReadBytes fills buf with a measurement in the format sent by the sensor.
+func (s *SimulatedBH1750) ReadBytes(buf []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raw := uint16(math.Round(math.Max(0, math.Min(65535, s.lux*1.2))))
+	return copy(buf, []byte{byte(raw >> 8), byte(raw)}), nil
+}
@@ -0,0 +1,211 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+// NutrientDosing is the nutrientDosing section of the config file.
+type NutrientDosing struct {
+	Target        float64  `yaml:"target"`        // mS/cm at 25c the crop is grown at.
+	MixRatio      MixRatio `yaml:"mixRatio"`      // parts of A to parts of B in every dose.
+	DoseTime      int64    `yaml:"doseTime"`      // seconds part A runs for each dose.
+	MixingTime    int64    `yaml:"mixingTime"`    // minutes to wait after a dose before reading again.
+	MaxDosePerDay int64    `yaml:"maxDosePerDay"` // seconds both pumps may run together in a day.
+}
+
+// MixRatio is the amount of part A to part B of the nutrient, 1 to 1 for most.
+type MixRatio struct {
+	A float64 `yaml:"a"`
+	B float64 `yaml:"b"`
+}
+
+type nutrientDosingConfig struct {
+	NutrientDosing NutrientDosing `yaml:"nutrientDosing"`
+}
+
+// NewNutrientDosing reads the nutrientDosing section of the config file.
+func NewNutrientDosing() (*NutrientDosing, error) {
+	var setting nutrientDosingConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	d := setting.NutrientDosing
+	if err := d.validate(); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (d NutrientDosing) validate() error {
+	if d.Target <= 0 {
+		return fmt.Errorf("nutrientDosing needs a target greater than 0")
+	}
+	if d.MixRatio.A <= 0 || d.MixRatio.B < 0 {
+		return fmt.Errorf("nutrientDosing needs a mixRatio with a greater than 0")
+	}
+	if d.DoseTime <= 0 || d.MaxDosePerDay < d.doseTimes().total() {
+		return fmt.Errorf("nutrientDosing needs a doseTime greater than 0 with a dose of both parts not greater than maxDosePerDay")
+	}
+	return nil
+}
+
+// partDoses is how long each pump runs for a dose.
+type partDoses struct {
+	a, b time.Duration
+}
+
+func (p partDoses) total() int64 {
+	return int64((p.a + p.b) / time.Second)
+}
+
+// doseTimes splits a dose between the pumps by the mix ratio, part A running for doseTime.
+func (d NutrientDosing) doseTimes() partDoses {
+	a := time.Second * time.Duration(d.DoseTime)
+	return partDoses{a: a, b: time.Duration(float64(a) * d.MixRatio.B / d.MixRatio.A)}
+}
+
+// Maintain adds nutrients when the EC falls below the target of the nutrientDosing setting.
+// Part A then part B are pulsed by the mix ratio, then the solution is left to mix before the
+// EC is read again. No pH is dosed until it is mixed. The pumps run for maxDosePerDay at most
+// in any 24 hours, the doses are saved so the limit holds after a restart. Every dose is sent
+// over the entry channel. It runs until ctx is done.
+func (ec *ECSensor) Maintain(ctx context.Context, a, b *OutputDevice, entry chan *types.LogEntry) error {
+	setting, err := NewNutrientDosing()
+	if err != nil {
+		return permanent(err)
+	}
+	if a == nil || b == nil {
+		return permanent(fmt.Errorf("nutrient dosing needs both the %s and %s devices", consts.NutrientAPump, consts.NutrientBPump))
+	}
+	if ec.Every <= 0 {
+		return permanent(fmt.Errorf("the ec sensor needs an every value greater than 0"))
+	}
+	history, err := loadDoseHistory(nutrientDoses, time.Now())
+	if err != nil {
+		entry <- &types.LogEntry{
+			Message: fmt.Sprintf("Something went wrong loading the nutrient doses of the last 24 hours, the limit counts from now. %v", err),
+			Success: false,
+			Time:    time.Now().Unix(),
+			Type:    string(consts.EC),
+		}
+	}
+	return maintainEC(ctx, *setting, ec.Get, a, b, history, time.Minute*time.Duration(ec.Every), entry)
+}
+
+// nutrientDoses is the name the doses of both nutrient pumps are saved under.
+const nutrientDoses = "nutrients"
+
+func maintainEC(ctx context.Context, setting NutrientDosing, read func() (*float64, error), a, b *OutputDevice, history *doseHistory, every time.Duration, entry chan *types.LogEntry) error {
+	doses := setting.doseTimes()
+	maxDose := time.Second * time.Duration(setting.MaxDosePerDay)
+	limitLogged := false
+
+	for {
+		value, err := read()
+		if err != nil {
+			entry <- &types.LogEntry{
+				Message: fmt.Sprintf("Something went wrong reading the EC %v", err),
+				Success: false,
+				Time:    time.Now().Unix(),
+				Type:    string(consts.EC),
+			}
+			if err := sleep(ctx, every); err != nil {
+				return err
+			}
+			continue
+		}
+		if *value >= setting.Target {
+			if err := sleep(ctx, every); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if history.within(time.Now(), 24*time.Hour)+doses.a+doses.b > maxDose {
+			if !limitLogged {
+				entry <- &types.LogEntry{
+					Message: fmt.Sprintf("The EC is %v but the nutrient pumps already ran for the maximum of %v today.", *value, maxDose),
+					Success: false,
+					Time:    time.Now().Unix(),
+					Type:    string(consts.EC),
+				}
+				limitLogged = true
+			}
+			if err := sleep(ctx, every); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := lockDosing(ctx); err != nil {
+			return err
+		}
+		// the other controllers may have dosed while the lock was waited for.
+		value, err = read()
+		if err != nil || *value >= setting.Target {
+			unlockDosing()
+			if err := sleep(ctx, every); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := pulseParts(a, b, doses, history); err != nil {
+			a.With(ReasonSafety).Off()
+			b.With(ReasonSafety).Off()
+			unlockDosing()
+			entry <- &types.LogEntry{
+				Message: fmt.Sprintf("Something went wrong dosing the nutrients %v", err),
+				Success: false,
+				Time:    time.Now().Unix(),
+				Type:    string(consts.EC),
+			}
+			if err := sleep(ctx, every); err != nil {
+				return err
+			}
+			continue
+		}
+		limitLogged = false
+		entry <- &types.LogEntry{
+			Message: fmt.Sprintf("The EC was %v, below the target of %v. Ran %s for %v and %s for %v.", *value, setting.Target, a.Name, doses.a, b.Name, doses.b),
+			Success: true,
+			Time:    time.Now().Unix(),
+			Type:    string(consts.EC),
+		}
+		err = sleep(ctx, time.Minute*time.Duration(setting.MixingTime))
+		unlockDosing()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// pulseParts runs part A then part B, the parts are never mixed undiluted. Each part is added to
+// the history once it has run, a part given before the other failed still counts to the limit.
+func pulseParts(a, b *OutputDevice, doses partDoses, history *doseHistory) error {
+	start := time.Now()
+	if err := pulse(a, doses.a); err != nil {
+		return err
+	}
+	history.add(start, doses.a)
+	if doses.b == 0 {
+		return nil
+	}
+	start = time.Now()
+	if err := pulse(b, doses.b); err != nil {
+		return err
+	}
+	history.add(start, doses.b)
+	return nil
+}
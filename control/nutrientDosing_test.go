@@ -0,0 +1,162 @@
+package control
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+func TestNutrientDoseTimes(t *testing.T) {
+	setting := NutrientDosing{Target: 1.6, MixRatio: MixRatio{A: 2, B: 1}, DoseTime: 4, MaxDosePerDay: 60}
+	if err := setting.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if doses := setting.doseTimes(); doses.a != 4*time.Second || doses.b != 2*time.Second {
+		t.Errorf("expected part A for 4s and part B for 2s, got %v and %v", doses.a, doses.b)
+	}
+	setting.MaxDosePerDay = 5
+	if err := setting.validate(); err == nil {
+		t.Error("expected an error when a single dose is above maxDosePerDay")
+	}
+}
+
+func TestMaintainEC(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	a := &OutputDevice{Name: "nutrientapump", Pin: 9, Rate: 1}
+	b := &OutputDevice{Name: "nutrientbpump", Pin: 11, Rate: 1}
+	setting := NutrientDosing{Target: 1.6, MixRatio: MixRatio{A: 1}, DoseTime: 1, MaxDosePerDay: 1}
+	low := func() (*float64, error) {
+		ec := 1.2
+		return &ec, nil
+	}
+
+	// a ph dose is mixing.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := lockDosing(ctx); err != nil {
+		t.Fatal(err)
+	}
+	entry := make(chan *types.LogEntry, 10)
+	done := make(chan error)
+	go func() {
+		done <- maintainEC(ctx, setting, low, a, b, &doseHistory{}, 10*time.Millisecond, entry)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if hw.PinState(9).High {
+		t.Fatal("expected no nutrients dosed while the ph dose is mixing")
+	}
+	unlockDosing()
+
+	for _, want := range []string{"below the target of 1.6", "maximum of 1s today"} {
+		select {
+		case e := <-entry:
+			if !strings.Contains(e.Message, want) {
+				t.Errorf("expected an entry containing %q, got %q", want, e.Message)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected an entry containing %q", want)
+		}
+	}
+	if hw.PinState(9).High || hw.PinState(11).High {
+		t.Error("expected both pumps off after the dose")
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected the dosing to stop with ctx, got %v", err)
+	}
+	if err := lockDosing(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	unlockDosing()
+}
+
+func TestMaintainECReadsAfterMixing(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	a := &OutputDevice{Name: "nutrientapump", Pin: 9, Rate: 1}
+	b := &OutputDevice{Name: "nutrientbpump", Pin: 11, Rate: 1}
+	setting := NutrientDosing{Target: 1.6, MixRatio: MixRatio{A: 1}, DoseTime: 1, MaxDosePerDay: 10}
+	var mu sync.Mutex
+	ec := 1.2
+	read := func() (*float64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		value := ec
+		return &value, nil
+	}
+
+	// a ph dose is mixing, it brings the ec back up.
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := lockDosing(ctx); err != nil {
+		t.Fatal(err)
+	}
+	entry := make(chan *types.LogEntry, 10)
+	done := make(chan error)
+	go func() {
+		done <- maintainEC(ctx, setting, read, a, b, &doseHistory{}, 10*time.Millisecond, entry)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	ec = 1.7
+	mu.Unlock()
+	unlockDosing()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected the dosing to stop with ctx, got %v", err)
+	}
+	select {
+	case e := <-entry:
+		t.Errorf("expected no dose once the ec is back above the target, got %q", e.Message)
+	default:
+	}
+}
+
+func TestPulsePartsRecordsEachPart(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	saved := tracker
+	tracker = &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	defer func() { tracker = saved }()
+	devices, err := newDeviceManager([]OutputDevice{
+		{Name: "nutrientapump", Pin: 9, Rate: 1},
+		{Name: "nutrientbpump", Pin: 11, Rate: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := devices.Device("nutrientapump")
+	b, _ := devices.Device("nutrientbpump")
+	// part B has to stay off, it just ran.
+	safety, err := newSafety(SafetySetting{Limits: []Limit{{Device: b.Name, MinOffTime: 5}}}, nil, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	safety.Enforce()
+	defer func() {
+		guardMu.Lock()
+		guard = nil
+		guardMu.Unlock()
+	}()
+	if err := b.Off(); err != nil {
+		t.Fatal(err)
+	}
+
+	history := &doseHistory{}
+	if err := pulseParts(a, b, partDoses{a: 10 * time.Millisecond, b: 5 * time.Millisecond}, history); err == nil {
+		t.Fatal("expected part B to fail")
+	}
+	if total := history.within(time.Now(), time.Hour); total != 10*time.Millisecond {
+		t.Errorf("expected part A counted in the history, got %v", total)
+	}
+}
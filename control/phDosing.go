@@ -0,0 +1,169 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+// PHDosing is the phDosing section of the config file.
+type PHDosing struct {
+	Low            float64 `yaml:"low"`
+	High           float64 `yaml:"high"`
+	DoseTime       int64   `yaml:"doseTime"`       // seconds a pump runs for each dose.
+	MixingTime     int64   `yaml:"mixingTime"`     // minutes to wait after a dose before reading again.
+	MaxDosePerHour int64   `yaml:"maxDosePerHour"` // seconds each pump may run in an hour.
+}
+
+type phDosingConfig struct {
+	PHDosing PHDosing `yaml:"phDosing"`
+}
+
+// NewPHDosing reads the phDosing section of the config file.
+func NewPHDosing() (*PHDosing, error) {
+	var setting phDosingConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	d := setting.PHDosing
+	if d.Low <= 0 || d.High <= d.Low {
+		return nil, fmt.Errorf("phDosing needs a low value below the high value")
+	}
+	if d.DoseTime <= 0 || d.MaxDosePerHour < d.DoseTime {
+		return nil, fmt.Errorf("phDosing needs a doseTime greater than 0 and not greater than maxDosePerHour")
+	}
+	return &d, nil
+}
+
+// Maintain keeps the pH inside the band set in the phDosing setting. When the pH is outside the
+// band the up or down pump is pulsed for doseTime, then the solution is left to mix before the
+// pH is read again. No nutrients are dosed until it is mixed. The doses are saved so the
+// maxDosePerHour holds after a restart. Every dose is sent over the entry channel. It runs until
+// ctx is done.
+func (ph *PHSensor) Maintain(ctx context.Context, up, down *OutputDevice, entry chan *types.LogEntry) error {
+	setting, err := NewPHDosing()
+	if err != nil {
+		return permanent(err)
+	}
+	if up == nil || down == nil {
+		return permanent(fmt.Errorf("ph dosing needs both the %s and %s devices", consts.PHUpPump, consts.PHDownPump))
+	}
+	if ph.Every <= 0 {
+		return permanent(fmt.Errorf("the ph sensor needs an every value greater than 0"))
+	}
+
+	history := map[consts.OutputDevice]*doseHistory{}
+	for _, pump := range []*OutputDevice{up, down} {
+		h, err := loadDoseHistory(string(pump.Name), time.Now())
+		if err != nil {
+			entry <- &types.LogEntry{
+				Message: fmt.Sprintf("Something went wrong loading the doses of %s, the limit counts from now. %v", pump.Name, err),
+				Success: false,
+				Time:    time.Now().Unix(),
+				Type:    string(consts.PH),
+			}
+		}
+		history[pump.Name] = h
+	}
+	doseTime := time.Second * time.Duration(setting.DoseTime)
+	maxDose := time.Second * time.Duration(setting.MaxDosePerHour)
+	every := time.Minute * time.Duration(ph.Every)
+	limitLogged := false
+
+	for {
+		value, err := ph.Get()
+		if err != nil {
+			entry <- &types.LogEntry{
+				Message: fmt.Sprintf("Something went wrong reading the pH %v", err),
+				Success: false,
+				Time:    time.Now().Unix(),
+				Type:    string(consts.PH),
+			}
+			if err := sleep(ctx, every); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var pump *OutputDevice
+		var limit float64
+		switch {
+		case *value < setting.Low:
+			pump, limit = up, setting.Low
+		case *value > setting.High:
+			pump, limit = down, setting.High
+		default:
+			if err := sleep(ctx, every); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if history[pump.Name].within(time.Now(), time.Hour)+doseTime > maxDose {
+			if !limitLogged {
+				entry <- &types.LogEntry{
+					Message: fmt.Sprintf("The pH is %v but %s already ran for the maximum of %v this hour.", *value, pump.Name, maxDose),
+					Success: false,
+					Time:    time.Now().Unix(),
+					Type:    string(consts.PH),
+				}
+				limitLogged = true
+			}
+			if err := sleep(ctx, every); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := lockDosing(ctx); err != nil {
+			return err
+		}
+		// the other controllers may have dosed while the lock was waited for.
+		value, err = ph.Get()
+		if err != nil || pump == up && *value >= setting.Low || pump == down && *value <= setting.High {
+			unlockDosing()
+			if err := sleep(ctx, every); err != nil {
+				return err
+			}
+			continue
+		}
+		start := time.Now()
+		if err := pulse(pump, doseTime); err != nil {
+			pump.With(ReasonSafety).Off()
+			unlockDosing()
+			entry <- &types.LogEntry{
+				Message: fmt.Sprintf("Something went wrong running %s %v", pump.Name, err),
+				Success: false,
+				Time:    time.Now().Unix(),
+				Type:    string(consts.PH),
+			}
+			if err := sleep(ctx, every); err != nil {
+				return err
+			}
+			continue
+		}
+		history[pump.Name].add(start, doseTime)
+		limitLogged = false
+		entry <- &types.LogEntry{
+			Message: fmt.Sprintf("The pH was %v, outside the limit of %v. Ran %s for %v seconds.", *value, limit, pump.Name, setting.DoseTime),
+			Success: true,
+			Time:    time.Now().Unix(),
+			Type:    string(consts.PH),
+		}
+		err = sleep(ctx, time.Minute*time.Duration(setting.MixingTime))
+		unlockDosing()
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -1,82 +1,214 @@
 package control
 
 import (
-	"encoding/json"
+	"fmt"
+	"math"
 	"time"
 
-	"github.com/only1isus/ADS1115"
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
 	"github.com/only1isus/majorProj/consts"
-	"github.com/only1isus/majorProj/rpc"
-	"github.com/only1isus/majorProj/types"
 )
 
-type PHSensor ADCSensor
+// PHSensor is a pH probe connected to the ADS1115. Its calibration is kept in the calibration
+// file under the name of the sensor.
+type PHSensor struct {
+	ADCSensor
+	Calibration      PHCalibration
+	Setting          PHCalibrationSetting
+	waterTemperature Sensor // nil without a water temperature sensor.
+}
 
-var (
-	defaultCalibrationValues = map[int][]float64{
-		4: []float64{4, 1.993},
-		7: []float64{7, 1.476},
-	}
-)
+// PHCalibration is the calibration record of a pH probe. The probe reads
+// offset + slope * (pH - 7) volts at 25c, the slope growing with the absolute temperature.
+type PHCalibration struct {
+	Time   int64           `json:"time"`
+	Slope  float64         `json:"slope"`  // volts per pH at 25c.
+	Offset float64         `json:"offset"` // volts at pH 7.
+	Points []PHBufferPoint `json:"points"`
+}
+
+// PHBufferPoint is the voltage read while the probe sits in a buffer solution.
+type PHBufferPoint struct {
+	PH          float64 `json:"ph"`          // 4, 7 or 10.
+	Temperature float64 `json:"temperature"` // c, of the buffer when it was read.
+	Voltage     float64 `json:"voltage"`
+}
+
+// PHCalibrationSetting is the phCalibration section of the config file. A calibration is
+// rejected when its slope or offset is too far from the ideal probe.
+type PHCalibrationSetting struct {
+	IdealSlope       float64 `yaml:"idealSlope"`       // volts per pH at 25c.
+	IdealOffset      float64 `yaml:"idealOffset"`      // volts at pH 7.
+	MinSlope         float64 `yaml:"minSlope"`         // % of the ideal slope.
+	MaxSlope         float64 `yaml:"maxSlope"`         // % of the ideal slope.
+	MaxOffset        float64 `yaml:"maxOffset"`        // volts away from the ideal offset.
+	WaterTemperature float64 `yaml:"waterTemperature"` // used by Get without a water temperature sensor.
+}
 
-func calculatePH(analogValue float64) float64 {
-	m := float64((defaultCalibrationValues[4][1] - defaultCalibrationValues[7][1]) / (defaultCalibrationValues[4][0] - defaultCalibrationValues[7][0]))
-	c := defaultCalibrationValues[4][1] - m*defaultCalibrationValues[4][0]
-	pHValue := (analogValue - c) / m
+type phCalibrationConfig struct {
+	PHCalibration PHCalibrationSetting `yaml:"phCalibration"`
+}
+
+// the response of the probe the controller was first built with, 1.993v at pH 4 and 1.476v at pH 7.
+var defaultPHCalibrationSetting = PHCalibrationSetting{
+	IdealSlope:       (1.476 - 1.993) / 3,
+	IdealOffset:      1.476,
+	MinSlope:         85,
+	MaxSlope:         105,
+	MaxOffset:        0.1,
+	WaterTemperature: 25,
+}
+
+// nernstFactor returns the ratio of the probe slope at temperature to the slope at 25c.
+func nernstFactor(temperature float64) float64 {
+	return (temperature + 273.15) / 298.15
+}
+
+// calculatePH returns the pH of the water at temperature read as analogValue volts.
+func calculatePH(analogValue, temperature float64, c PHCalibration) float64 {
+	pHValue := 7 + (analogValue-c.Offset)/(c.Slope*nernstFactor(temperature))
 	return ToFixed(pHValue, 2)
 }
 
-func NewPHSensor(connection *ADS1115.ADS1115) (*PHSensor, error) {
+// fitPHCalibration fits the slope and offset to the buffer points. A single point keeps the ideal
+// slope and only moves the offset. Two or three points are fitted using least squares.
+func fitPHCalibration(points []PHBufferPoint, setting PHCalibrationSetting) (*PHCalibration, error) {
+	if len(points) < 1 || len(points) > 3 {
+		return nil, fmt.Errorf("calibration takes 1, 2 or 3 buffer points, got %d", len(points))
+	}
+	c := &PHCalibration{Time: time.Now().Unix(), Points: points}
+
+	// the voltage is linear in x = (pH - 7) * nernstFactor(temperature).
+	var sumX, sumY, sumXX, sumXY float64
+	for _, p := range points {
+		x := (p.PH - 7) * nernstFactor(p.Temperature)
+		sumX += x
+		sumY += p.Voltage
+		sumXX += x * x
+		sumXY += x * p.Voltage
+	}
+	n := float64(len(points))
+	if len(points) == 1 {
+		c.Slope = setting.IdealSlope
+		c.Offset = (sumY - c.Slope*sumX) / n
+	} else {
+		denominator := n*sumXX - sumX*sumX
+		if denominator == 0 {
+			return nil, fmt.Errorf("the buffer points need different pH values")
+		}
+		c.Slope = (n*sumXY - sumX*sumY) / denominator
+		c.Offset = (sumY - c.Slope*sumX) / n
+	}
+
+	if err := c.check(setting); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SlopePercent returns the slope as a percentage of the ideal slope.
+func (c PHCalibration) SlopePercent(setting PHCalibrationSetting) float64 {
+	return 100 * c.Slope / setting.IdealSlope
+}
+
+// check returns an error when the probe is too far from the ideal probe to be trusted.
+func (c PHCalibration) check(setting PHCalibrationSetting) error {
+	slope := c.SlopePercent(setting)
+	if slope < setting.MinSlope || slope > setting.MaxSlope {
+		return fmt.Errorf("the probe slope is %.1f%% of the ideal slope, expected %v%% - %v%%. Clean or replace the probe", slope, setting.MinSlope, setting.MaxSlope)
+	}
+	if offset := c.Offset - setting.IdealOffset; math.Abs(offset) > setting.MaxOffset {
+		return fmt.Errorf("the probe offset is %.3fv from the ideal offset, expected at most %vv. Check the buffers or replace the probe", offset, setting.MaxOffset)
+	}
+	return nil
+}
+
+// NewPHSensor returns the ph sensor from the analogSensor setting using the calibration saved
+// for it. The ideal probe is used until the sensor is calibrated.
+func NewPHSensor(connection ADC) (*PHSensor, error) {
 
 	phsensor, err := NewAnalogSensor(consts.PHSensor)
 	if err != nil {
 		return nil, err
 	}
-	phsensor.connection = connection
-	ph := PHSensor(*phsensor)
-	return &ph, nil
+	return newPHSensor(*phsensor, connection)
 }
 
-func (ph *PHSensor) Calibrate(bufferSolution4 float64, bufferSolution7 float64) {
-	defaultCalibrationValues[4][1] = bufferSolution4
-	defaultCalibrationValues[7][1] = bufferSolution7
+func newPHSensor(sensor ADCSensor, connection ADC) (*PHSensor, error) {
+	sensor.connection = connection
+	setting := phCalibrationConfig{PHCalibration: defaultPHCalibrationSetting}
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+
+	ph := &PHSensor{
+		ADCSensor: sensor,
+		Setting:   setting.PHCalibration,
+		Calibration: PHCalibration{
+			Slope:  setting.PHCalibration.IdealSlope,
+			Offset: setting.PHCalibration.IdealOffset,
+		},
+	}
+	if _, err := loadCalibration(string(ph.Name), &ph.Calibration); err != nil {
+		return nil, err
+	}
+	return ph, nil
+}
+
+// ReadBuffer reads the probe while it sits in a buffer solution of the pH and temperature given.
+// The results are passed to Calibrate.
+func (ph *PHSensor) ReadBuffer(pH, temperature float64) (*PHBufferPoint, error) {
+	voltage, err := ph.connection.Read(ph.AnalogPin)
+	if err != nil {
+		return nil, err
+	}
+	return &PHBufferPoint{PH: pH, Temperature: temperature, Voltage: voltage}, nil
 }
 
+// Calibrate fits the probe to 1, 2 or 3 buffer points, usually pH 4, 7 and 10. The calibration
+// is only used and saved when the slope and offset pass the health checks.
+func (ph *PHSensor) Calibrate(points ...PHBufferPoint) (*PHCalibration, error) {
+	c, err := fitPHCalibration(points, ph.Setting)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveCalibration(string(ph.Name), c); err != nil {
+		return nil, err
+	}
+	ph.Calibration = *c
+	return c, nil
+}
+
+// Get returns the pH compensated using the water temperature sensor, or the waterTemperature
+// setting when there is none.
 func (ph *PHSensor) Get() (*float64, error) {
+	if ph.waterTemperature == nil {
+		return ph.GetCompensated(ph.Setting.WaterTemperature)
+	}
+	temperature, err := ph.waterTemperature.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the water temperature to compensate the pH. %v", err)
+	}
+	return ph.GetCompensated(temperature)
+}
+
+// GetCompensated returns the pH of water at the temperature given.
+func (ph *PHSensor) GetCompensated(waterTemperature float64) (*float64, error) {
 	voltageValue, err := ph.connection.Read(ph.AnalogPin)
 	if err != nil {
 		return nil, err
 	}
 	out := new(float64)
-	*out = calculatePH(float64(voltageValue))
+	*out = calculatePH(voltageValue, waterTemperature, ph.Calibration)
 	return out, nil
 }
 
-func (ph PHSensor) ReadAndCommit() error {
-	for {
-		timer := time.NewTimer(time.Minute * time.Duration(ph.Every))
-		defer timer.Stop()
-		// wait for the timer to reach its limit
-		<-timer.C
-		phvalue, err := ph.Get()
-		if err != nil {
-			return err
-		}
-		data := &types.SensorEntry{
-			SensorType: consts.PH,
-			Time:       time.Now().Unix(),
-			Value:      *phvalue,
-		}
-		out, err := json.Marshal(data)
-		if err != nil {
-			return err
-		}
-		if err := rpc.CommitSensorData(&out); err != nil {
-			return err
-		}
-	}
-}
-
 func (ph *PHSensor) Close() error {
 	return ph.connection.Close()
 }
@@ -0,0 +1,48 @@
+package control
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitPHCalibration(t *testing.T) {
+	setting := defaultPHCalibrationSetting
+
+	// a probe at 95% of the ideal slope read in buffers at 20c.
+	slope, offset := 0.95*setting.IdealSlope, 1.49
+	var points []PHBufferPoint
+	for _, pH := range []float64{4, 7, 10} {
+		voltage := offset + slope*(pH-7)*nernstFactor(20)
+		points = append(points, PHBufferPoint{PH: pH, Temperature: 20, Voltage: voltage})
+	}
+	c, err := fitPHCalibration(points, setting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(c.Slope-slope) > 1e-9 || math.Abs(c.Offset-offset) > 1e-9 {
+		t.Errorf("expected a slope of %v and an offset of %v, got %v and %v", slope, offset, c.Slope, c.Offset)
+	}
+	if pH := calculatePH(offset+slope*(5.5-7)*nernstFactor(30), 30, *c); pH != 5.5 {
+		t.Errorf("expected pH 5.5 at 30c, got %v", pH)
+	}
+
+	c, err = fitPHCalibration(points[1:2], setting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Slope != setting.IdealSlope || c.Offset != offset {
+		t.Errorf("expected a single point to only move the offset, got %+v", c)
+	}
+
+	// a worn probe at 70% of the ideal slope.
+	worn := []PHBufferPoint{
+		{PH: 4, Temperature: 25, Voltage: offset - 0.7*setting.IdealSlope*3},
+		{PH: 7, Temperature: 25, Voltage: offset},
+	}
+	if _, err := fitPHCalibration(worn, setting); err == nil {
+		t.Error("expected a worn probe to be rejected")
+	}
+	if _, err := fitPHCalibration([]PHBufferPoint{{PH: 7, Temperature: 25, Voltage: 1.8}}, setting); err == nil {
+		t.Error("expected an offset 0.32v away to be rejected")
+	}
+}
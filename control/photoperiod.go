@@ -0,0 +1,142 @@
+package control
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+)
+
+const (
+	clockLayout = "15:04"
+	dateLayout  = "2006-01-02"
+)
+
+// Photoperiod is the photoperiod section of the config file. It sets the time of day the grow
+// light is on, optionally for each growth stage of the crop. An on time equal to the off time
+// keeps the light on all day.
+type Photoperiod struct {
+	On        string        `yaml:"on"`        // 15:04
+	Off       string        `yaml:"off"`       // 15:04
+	PlantedOn string        `yaml:"plantedOn"` // 2006-01-02, the first day of the first stage.
+	Stages    []GrowthStage `yaml:"stages"`
+}
+
+// GrowthStage is a photoperiod used for a number of days. The last stage lasts until harvest
+// and does not need the days set.
+type GrowthStage struct {
+	Name string `yaml:"name"`
+	Days int    `yaml:"days"`
+	On   string `yaml:"on"`
+	Off  string `yaml:"off"`
+}
+
+type photoperiodConfig struct {
+	Photoperiod *Photoperiod `yaml:"photoperiod"`
+}
+
+// NewPhotoperiod reads the photoperiod section of the config file. It returns nil when the
+// section is not set.
+func NewPhotoperiod() (*Photoperiod, error) {
+	var setting photoperiodConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	p := setting.Photoperiod
+	if p == nil {
+		return nil, nil
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Photoperiod) validate() error {
+	if len(p.Stages) == 0 {
+		if _, err := clockWindow(p.On, p.Off); err != nil {
+			return err
+		}
+		return nil
+	}
+	if _, err := time.ParseInLocation(dateLayout, p.PlantedOn, time.Local); err != nil {
+		return fmt.Errorf("photoperiod plantedOn must be set as %s when using stages. %v", dateLayout, err)
+	}
+	for i, stage := range p.Stages {
+		if _, err := clockWindow(stage.On, stage.Off); err != nil {
+			return fmt.Errorf("stage %s: %v", stage.Name, err)
+		}
+		if stage.Days <= 0 && i != len(p.Stages)-1 {
+			return fmt.Errorf("stage %s needs the number of days it lasts", stage.Name)
+		}
+	}
+	return nil
+}
+
+// Stage returns the growth stage at t, nil when no stages are set.
+func (p *Photoperiod) Stage(t time.Time) *GrowthStage {
+	if len(p.Stages) == 0 {
+		return nil
+	}
+	planted, err := time.ParseInLocation(dateLayout, p.PlantedOn, t.Location())
+	if err != nil {
+		return &p.Stages[0]
+	}
+	day := int(t.Sub(planted).Hours() / 24)
+	for i := range p.Stages {
+		if day < p.Stages[i].Days || i == len(p.Stages)-1 {
+			return &p.Stages[i]
+		}
+		day -= p.Stages[i].Days
+	}
+	return &p.Stages[len(p.Stages)-1]
+}
+
+// IsOn reports whether the light should be on at t.
+func (p *Photoperiod) IsOn(t time.Time) (bool, error) {
+	on, off := p.On, p.Off
+	if stage := p.Stage(t); stage != nil {
+		on, off = stage.On, stage.Off
+	}
+	window, err := clockWindow(on, off)
+	if err != nil {
+		return false, err
+	}
+	return window.contains(t), nil
+}
+
+// window is a time of day range in minutes after midnight.
+type window struct {
+	on, off int
+}
+
+func clockWindow(on, off string) (window, error) {
+	onTime, err := time.Parse(clockLayout, on)
+	if err != nil {
+		return window{}, fmt.Errorf("cannot read the on time %q, use %s", on, clockLayout)
+	}
+	offTime, err := time.Parse(clockLayout, off)
+	if err != nil {
+		return window{}, fmt.Errorf("cannot read the off time %q, use %s", off, clockLayout)
+	}
+	return window{on: onTime.Hour()*60 + onTime.Minute(), off: offTime.Hour()*60 + offTime.Minute()}, nil
+}
+
+func (w window) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	switch {
+	case w.on == w.off:
+		return true
+	case w.on < w.off:
+		return minute >= w.on && minute < w.off
+	default:
+		// the window runs past midnight.
+		return minute >= w.on || minute < w.off
+	}
+}
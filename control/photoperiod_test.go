@@ -0,0 +1,71 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhotoperiodWindow(t *testing.T) {
+	at := func(clock string) time.Time {
+		ti, _ := time.ParseInLocation("2006-01-02 15:04", "2026-10-18 "+clock, time.Local)
+		return ti
+	}
+	tt := []struct {
+		on, off, at string
+		expected    bool
+	}{
+		{on: "06:00", off: "22:00", at: "05:59", expected: false},
+		{on: "06:00", off: "22:00", at: "06:00", expected: true},
+		{on: "06:00", off: "22:00", at: "22:00", expected: false},
+		{on: "18:00", off: "06:00", at: "23:30", expected: true},
+		{on: "18:00", off: "06:00", at: "03:00", expected: true},
+		{on: "18:00", off: "06:00", at: "12:00", expected: false},
+		{on: "00:00", off: "00:00", at: "12:00", expected: true},
+	}
+	for _, test := range tt {
+		p := &Photoperiod{On: test.on, Off: test.off}
+		on, err := p.IsOn(at(test.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if on != test.expected {
+			t.Errorf("%s - %s at %s: expected %v, got %v", test.on, test.off, test.at, test.expected, on)
+		}
+	}
+}
+
+func TestPhotoperiodStages(t *testing.T) {
+	p := &Photoperiod{
+		PlantedOn: "2026-10-01",
+		Stages: []GrowthStage{
+			{Name: "seedling", Days: 14, On: "05:00", Off: "23:00"},
+			{Name: "vegetative", Days: 28, On: "06:00", Off: "22:00"},
+			{Name: "mature", On: "06:00", Off: "20:00"},
+		},
+	}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	day := func(date string) time.Time {
+		ti, _ := time.ParseInLocation("2006-01-02 15:04", date+" 21:00", time.Local)
+		return ti
+	}
+	tt := []struct {
+		date, stage string
+		on          bool
+	}{
+		{date: "2026-10-05", stage: "seedling", on: true},
+		{date: "2026-10-14", stage: "seedling", on: true},
+		{date: "2026-10-15", stage: "vegetative", on: true},
+		{date: "2026-11-12", stage: "mature", on: false},
+		{date: "2027-02-01", stage: "mature", on: false},
+	}
+	for _, test := range tt {
+		if stage := p.Stage(day(test.date)); stage.Name != test.stage {
+			t.Errorf("%s: expected the %s stage, got %s", test.date, test.stage, stage.Name)
+		}
+		if on, _ := p.IsOn(day(test.date)); on != test.on {
+			t.Errorf("%s: expected the light on to be %v", test.date, test.on)
+		}
+	}
+}
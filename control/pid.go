@@ -0,0 +1,77 @@
+package control
+
+import (
+	"math"
+	"time"
+)
+
+// PID is a proportional-integral-derivative controller with output limits. The integral stops
+// growing while the output is saturated so it does not wind up.
+type PID struct {
+	Setpoint  float64
+	Kp        float64
+	Ki        float64 // per second
+	Kd        float64 // seconds
+	OutputMin float64
+	OutputMax float64
+	// Reverse is set when the output should rise with the measurement, such as a cooling fan.
+	Reverse bool
+
+	integral    float64
+	previous    float64
+	initialized bool
+}
+
+// PIDResult holds the output of an update along with each of the terms making it up.
+type PIDResult struct {
+	Error     float64
+	P, I, D   float64
+	Output    float64
+	Saturated bool
+}
+
+// Update feeds the controller a new measurement taken dt after the last one and returns the
+// new output.
+func (p *PID) Update(measurement float64, dt time.Duration) PIDResult {
+	seconds := dt.Seconds()
+	err := p.Setpoint - measurement
+	if p.Reverse {
+		err = -err
+	}
+
+	// the derivative is taken on the measurement so a change of setpoint does not kick the output.
+	derivative := 0.0
+	if p.initialized && seconds > 0 {
+		derivative = -(measurement - p.previous) / seconds
+		if p.Reverse {
+			derivative = -derivative
+		}
+	}
+	p.previous = measurement
+	p.initialized = true
+
+	integral := p.integral + err*seconds
+	unclamped := p.Kp*err + p.Ki*integral + p.Kd*derivative
+	output := math.Max(p.OutputMin, math.Min(p.OutputMax, unclamped))
+	saturated := output != unclamped
+	// only integrate when it does not push the output further into saturation.
+	if !saturated || (unclamped > p.OutputMax && err < 0) || (unclamped < p.OutputMin && err > 0) {
+		p.integral = integral
+	}
+
+	return PIDResult{
+		Error:     err,
+		P:         p.Kp * err,
+		I:         p.Ki * p.integral,
+		D:         p.Kd * derivative,
+		Output:    output,
+		Saturated: saturated,
+	}
+}
+
+// Reset clears the integral and derivative history.
+func (p *PID) Reset() {
+	p.integral = 0
+	p.previous = 0
+	p.initialized = false
+}
@@ -0,0 +1,34 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPIDCoolingDirection(t *testing.T) {
+	pid := &PID{Setpoint: 28, Kp: 0.5, OutputMin: 0, OutputMax: 1, Reverse: true}
+	if out := pid.Update(30, time.Second).Output; out != 1 {
+		t.Errorf("expected a full output 2c above the setpoint, got %v", out)
+	}
+	if out := pid.Update(27, time.Second).Output; out != 0 {
+		t.Errorf("expected no output below the setpoint, got %v", out)
+	}
+}
+
+func TestPIDAntiWindup(t *testing.T) {
+	pid := &PID{Setpoint: 28, Ki: 0.1, OutputMin: 0, OutputMax: 1, Reverse: true}
+	// an hour spent far above the setpoint saturates the output.
+	for i := 0; i < 3600; i++ {
+		if result := pid.Update(35, time.Second); !result.Saturated && i > 10 {
+			t.Fatalf("expected the output to be saturated after %d seconds", i)
+		}
+	}
+	// without anti-windup the integral would keep the fan on long after this.
+	result := pid.Update(27, time.Second)
+	for i := 0; i < 20 && result.Output > 0; i++ {
+		result = pid.Update(27, time.Second)
+	}
+	if result.Output > 0 {
+		t.Errorf("expected the output to recover quickly below the setpoint, got %v", result.Output)
+	}
+}
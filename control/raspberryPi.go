@@ -0,0 +1,75 @@
+package control
+
+import (
+	i2c "github.com/d2r2/go-i2c"
+	"github.com/only1isus/ADS1115"
+	"github.com/stianeikeland/go-rpio"
+)
+
+// RaspberryPi is the hardware backend for the pins and buses of a Raspberry Pi.
+type RaspberryPi struct{}
+
+type rpioGPIO struct{}
+
+// rpioPin reads the level of a pin as a bool.
+type rpioPin struct {
+	rpio.Pin
+}
+
+type ads1115ADC struct {
+	device *ADS1115.ADS1115
+}
+
+// OpenGPIO maps the GPIO memory of the pi.
+func (RaspberryPi) OpenGPIO() (GPIO, error) {
+	if err := rpio.Open(); err != nil {
+		return nil, err
+	}
+	return rpioGPIO{}, nil
+}
+
+// OpenI2C opens the device at address on the I2C bus.
+func (RaspberryPi) OpenI2C(address uint8, bus int) (I2CDevice, error) {
+	return i2c.NewI2C(address, bus)
+}
+
+// OpenADC opens the ADS1115 at address on the I2C bus.
+func (RaspberryPi) OpenADC(address, bus int) (ADC, error) {
+	conn, err := ADS1115.NewConnection(address, bus)
+	if err != nil {
+		return nil, err
+	}
+	return ads1115ADC{device: ADS1115.NewADS1115Device(conn)}, nil
+}
+
+func (rpioGPIO) Pin(number uint8) Pin {
+	return rpioPin{rpio.Pin(number)}
+}
+
+func (p rpioPin) Read() bool {
+	return p.Pin.Read() == rpio.High
+}
+
+func (rpioGPIO) StartPwm() {
+	rpio.StartPwm()
+}
+
+func (rpioGPIO) StopPwm() {
+	rpio.StopPwm()
+}
+
+func (rpioGPIO) Close() error {
+	return rpio.Close()
+}
+
+func (a ads1115ADC) Read(channel int) (float64, error) {
+	value, err := a.device.Read(channel)
+	if err != nil {
+		return 0, err
+	}
+	return float64(value), nil
+}
+
+func (a ads1115ADC) Close() error {
+	return a.device.Close()
+}
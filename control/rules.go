@@ -0,0 +1,428 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+// ruleInterval is the time between two evaluations of the rules.
+const ruleInterval = 30 * time.Second
+
+// Rule is an entry of the rules section of the config file. The rule fires when every condition
+// under all and at least one condition under any are met, running the then actions. The else
+// actions are run when the rule stops being met.
+type Rule struct {
+	Name string      `yaml:"name"`
+	All  []Condition `yaml:"all"`
+	Any  []Condition `yaml:"any"`
+	Then []Action    `yaml:"then"`
+	Else []Action    `yaml:"else"`
+}
+
+// Condition compares the reading of a sensor, named or given by its kind, with a threshold. The
+// reading has to stay past the threshold for the number of minutes set before the condition is
+// met. Once met it stays met until the reading comes back past the threshold by the hysteresis.
+type Condition struct {
+	Sensor     string   `yaml:"sensor"`
+	Above      *float64 `yaml:"above"`
+	Below      *float64 `yaml:"below"`
+	For        int64    `yaml:"for"` // minutes
+	Hysteresis float64  `yaml:"hysteresis"`
+}
+
+// Action turns a device on, at rate when it is set, or off. Notify adds the message to the log
+// entry of the rule.
+type Action struct {
+	Device consts.OutputDevice `yaml:"device"`
+	Action switchAction        `yaml:"action"` // on or off
+	Rate   float64             `yaml:"rate"`
+	Notify string              `yaml:"notify"`
+}
+
+// switchAction is on or off. yaml reads an unquoted on or off as a bool so both are accepted.
+type switchAction string
+
+func (a *switchAction) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "on", "true":
+		*a = "on"
+	case "off", "false":
+		*a = "off"
+	default:
+		*a = switchAction(strings.Trim(string(data), `"`))
+	}
+	return nil
+}
+
+// ruleHolds are the devices turned off by the then actions of the rules still met. A cycle
+// does not turn a held device back on. The holds are only kept in memory, after a restart the
+// rules are evaluated again.
+type ruleHolds struct {
+	mu    sync.Mutex
+	rules map[consts.OutputDevice]map[string]bool
+}
+
+var holds = &ruleHolds{rules: map[consts.OutputDevice]map[string]bool{}}
+
+// hold holds the device off for the rule.
+func (h *ruleHolds) hold(device consts.OutputDevice, rule string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.rules[device] == nil {
+		h.rules[device] = map[string]bool{}
+	}
+	h.rules[device][rule] = true
+}
+
+// release drops every hold of the rule.
+func (h *ruleHolds) release(rule string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, rules := range h.rules {
+		delete(rules, rule)
+	}
+}
+
+// held reports whether a rule holds the device off.
+func (h *ruleHolds) held(device consts.OutputDevice) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.rules[device]) > 0
+}
+
+type rulesConfig struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// RuleEngine evaluates the rules against the sensors of the registry.
+type RuleEngine struct {
+	rules   []*ruleState
+	sensors *Registry
+	devices *DeviceManager
+}
+
+type ruleState struct {
+	Rule
+	all, any []*conditionState
+	met      bool
+	failing  bool // a sensor of the rule could not be read at the last evaluation.
+}
+
+type conditionState struct {
+	Condition
+	sensor Sensor
+	since  time.Time // when the reading went past the threshold, zero when it is not past it.
+	met    bool
+	value  float64
+}
+
+// NewRuleEngine reads the rules section of the config file. Every sensor and device used by a
+// rule has to be in the registry and the device manager.
+func NewRuleEngine(sensors *Registry, devices *DeviceManager) (*RuleEngine, error) {
+	var setting rulesConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	return newRuleEngine(setting.Rules, sensors, devices)
+}
+
+func newRuleEngine(rules []Rule, sensors *Registry, devices *DeviceManager) (*RuleEngine, error) {
+	e := &RuleEngine{sensors: sensors, devices: devices}
+	for _, r := range rules {
+		state := &ruleState{Rule: r}
+		if len(r.All) == 0 && len(r.Any) == 0 {
+			return nil, fmt.Errorf("rule %s needs at least one condition", r.Name)
+		}
+		if len(r.Then) == 0 && len(r.Else) == 0 {
+			return nil, fmt.Errorf("rule %s needs at least one action", r.Name)
+		}
+		for _, c := range r.All {
+			cs, err := e.condition(c)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %v", r.Name, err)
+			}
+			state.all = append(state.all, cs)
+		}
+		for _, c := range r.Any {
+			cs, err := e.condition(c)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %v", r.Name, err)
+			}
+			state.any = append(state.any, cs)
+		}
+		for _, a := range append(append([]Action{}, r.Then...), r.Else...) {
+			if err := e.checkAction(a); err != nil {
+				return nil, fmt.Errorf("rule %s: %v", r.Name, err)
+			}
+		}
+		e.rules = append(e.rules, state)
+	}
+	return e, nil
+}
+
+func (e *RuleEngine) condition(c Condition) (*conditionState, error) {
+	if (c.Above == nil) == (c.Below == nil) {
+		return nil, fmt.Errorf("the condition on %s needs either above or below", c.Sensor)
+	}
+	if c.For < 0 || c.Hysteresis < 0 {
+		return nil, fmt.Errorf("the condition on %s cannot have a negative for or hysteresis", c.Sensor)
+	}
+	sensor, ok := e.sensors.find(c.Sensor)
+	if !ok {
+		return nil, fmt.Errorf("cannot find the sensor %s", c.Sensor)
+	}
+	return &conditionState{Condition: c, sensor: sensor}, nil
+}
+
+func (e *RuleEngine) checkAction(a Action) error {
+	if a.Device == "" {
+		if a.Notify == "" {
+			return fmt.Errorf("an action needs a device or a notify message")
+		}
+		return nil
+	}
+	if _, err := e.devices.Device(a.Device); err != nil {
+		return err
+	}
+	if a.Action != "on" && a.Action != "off" {
+		return fmt.Errorf("the action on %s needs to be on or off, got %q", a.Device, a.Action)
+	}
+	if a.Rate < 0 || a.Rate > 1 {
+		return fmt.Errorf("the action on %s needs a rate between 0 and 1", a.Device)
+	}
+	return nil
+}
+
+// Run evaluates the rules every 30 seconds until ctx is done. Every firing is sent over the
+// entry channel.
+func (e *RuleEngine) Run(ctx context.Context, entry chan *types.LogEntry) error {
+	if len(e.rules) == 0 {
+		return nil
+	}
+	ticker := time.NewTicker(ruleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		for _, logEntry := range e.evaluate(time.Now()) {
+			select {
+			case entry <- logEntry:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// evaluate updates every rule with a new reading of its sensors and runs the actions of the
+// rules that started or stopped being met.
+func (e *RuleEngine) evaluate(now time.Time) []*types.LogEntry {
+	var entries []*types.LogEntry
+	// each sensor is read once for all the rules.
+	readings := map[Sensor]float64{}
+	failed := map[Sensor]error{}
+	read := func(s Sensor) (float64, error) {
+		if err, ok := failed[s]; ok {
+			return 0, err
+		}
+		if value, ok := readings[s]; ok {
+			return value, nil
+		}
+		value, err := s.Read()
+		if err != nil {
+			failed[s] = err
+			return 0, err
+		}
+		readings[s] = value
+		return value, nil
+	}
+
+	for _, r := range e.rules {
+		met, err := r.update(now, read)
+		if err != nil {
+			// a broken sensor is logged once, not at every evaluation.
+			if !r.failing {
+				r.failing = true
+				entries = append(entries, &types.LogEntry{
+					Message: fmt.Sprintf("Rule %s was not evaluated. %v", r.Name, err),
+					Success: false,
+					Time:    now.Unix(),
+					Type:    "rule",
+				})
+			}
+			continue
+		}
+		if r.failing {
+			r.failing = false
+			entries = append(entries, &types.LogEntry{
+				Message: fmt.Sprintf("Rule %s is evaluated again, its sensors are read.", r.Name),
+				Success: true,
+				Time:    now.Unix(),
+				Type:    "rule",
+			})
+		}
+		if met == r.met {
+			continue
+		}
+		r.met = met
+		actions, event := r.Then, "fired"
+		if !met {
+			actions, event = r.Else, "cleared"
+			holds.release(r.Name)
+		}
+		entries = append(entries, e.run(r, event, actions, now))
+		if met {
+			for _, a := range r.Then {
+				if a.Device != "" && a.Action == "off" {
+					holds.hold(a.Device, r.Name)
+				}
+			}
+		}
+	}
+	return entries
+}
+
+func (e *RuleEngine) run(r *ruleState, event string, actions []Action, now time.Time) *types.LogEntry {
+	var done, notes, failures []string
+	for _, a := range actions {
+		if a.Notify != "" {
+			notes = append(notes, a.Notify)
+		}
+		if a.Device == "" {
+			continue
+		}
+		device, err := e.devices.Device(a.Device)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		d := device.With(ReasonRule)
+		if a.Action == "off" {
+			err = d.Off()
+		} else {
+			if a.Rate > 0 {
+				d.Rate = a.Rate
+			}
+			err = d.On()
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("cannot turn %s %s %v", a.Device, a.Action, err))
+			continue
+		}
+		if a.Action == "on" && !d.usesRelay() {
+			done = append(done, fmt.Sprintf("turned %s on at %v", a.Device, d.Rate))
+		} else {
+			done = append(done, fmt.Sprintf("turned %s %s", a.Device, a.Action))
+		}
+	}
+
+	message := fmt.Sprintf("Rule %s %s (%s).", r.Name, event, r.describe())
+	if len(done) > 0 {
+		message = fmt.Sprintf("%s %s.", message, strings.Join(done, ", "))
+	}
+	if len(failures) > 0 {
+		message = fmt.Sprintf("%s Failed: %s.", message, strings.Join(failures, ", "))
+	}
+	if len(notes) > 0 {
+		message = fmt.Sprintf("%s %s", message, strings.Join(notes, " "))
+	}
+	return &types.LogEntry{
+		Message: message,
+		Success: len(failures) == 0,
+		Time:    now.Unix(),
+		Type:    "rule",
+	}
+}
+
+// update reads the sensors of the rule and returns whether the rule is met.
+func (r *ruleState) update(now time.Time, read func(Sensor) (float64, error)) (bool, error) {
+	for _, c := range append(append([]*conditionState{}, r.all...), r.any...) {
+		value, err := read(c.sensor)
+		if err != nil {
+			return false, fmt.Errorf("cannot read %s %v", c.sensor.Name(), err)
+		}
+		c.update(now, value)
+	}
+	for _, c := range r.all {
+		if !c.met {
+			return false, nil
+		}
+	}
+	if len(r.any) == 0 {
+		return true, nil
+	}
+	for _, c := range r.any {
+		if c.met {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *conditionState) update(now time.Time, value float64) {
+	c.value = value
+	var past bool
+	switch {
+	case c.Above != nil && c.met:
+		past = value > *c.Above-c.Hysteresis
+	case c.Above != nil:
+		past = value > *c.Above
+	case c.met:
+		past = value < *c.Below+c.Hysteresis
+	default:
+		past = value < *c.Below
+	}
+	if !past {
+		c.since, c.met = time.Time{}, false
+		return
+	}
+	if c.since.IsZero() {
+		c.since = now
+	}
+	c.met = now.Sub(c.since) >= time.Minute*time.Duration(c.For)
+}
+
+// describe returns the conditions of the rule with the last readings.
+func (r *ruleState) describe() string {
+	describe := func(conditions []*conditionState) []string {
+		var out []string
+		for _, c := range conditions {
+			var limit string
+			if c.Above != nil {
+				limit = fmt.Sprintf("above %v", *c.Above)
+			} else {
+				limit = fmt.Sprintf("below %v", *c.Below)
+			}
+			if c.For > 0 {
+				limit = fmt.Sprintf("%s for %vm", limit, c.For)
+			}
+			out = append(out, fmt.Sprintf("%s %v, %s", c.sensor.Name(), c.value, limit))
+		}
+		return out
+	}
+	allOf, anyOf := describe(r.all), describe(r.any)
+	switch {
+	case len(anyOf) == 0:
+		return strings.Join(allOf, " and ")
+	case len(allOf) == 0:
+		return strings.Join(anyOf, " or ")
+	default:
+		return fmt.Sprintf("%s and (%s)", strings.Join(allOf, " and "), strings.Join(anyOf, " or "))
+	}
+}
@@ -0,0 +1,154 @@
+package control
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+)
+
+func TestRuleEngine(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	light := NewSimulatedBH1750(500)
+	hw.Attach(0x23, 1, light)
+
+	sensors, err := newRegistry(nil, []I2CSensor{{Name: "bh1750", Bus: 1, Address: 0x23}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := newDeviceManager([]OutputDevice{{Name: consts.AirPump, Pin: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	above := 1000.0
+	engine, err := newRuleEngine([]Rule{{
+		Name: "bright",
+		All:  []Condition{{Sensor: "light", Above: &above, For: 2, Hysteresis: 100}},
+		Then: []Action{{Device: consts.AirPump, Action: "on"}},
+		Else: []Action{{Device: consts.AirPump, Action: "off"}},
+	}}, sensors, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	tt := []struct {
+		minute  int
+		lux     float64
+		on      bool
+		entries int
+	}{
+		{minute: 0, lux: 1200, on: false, entries: 0},
+		// met after 2 minutes above the threshold.
+		{minute: 2, lux: 1200, on: true, entries: 1},
+		// still met inside the hysteresis.
+		{minute: 3, lux: 950, on: true, entries: 0},
+		{minute: 4, lux: 850, on: false, entries: 1},
+		{minute: 5, lux: 1200, on: false, entries: 0},
+	}
+	for _, tc := range tt {
+		light.Set(tc.lux)
+		entries := engine.evaluate(start.Add(time.Minute * time.Duration(tc.minute)))
+		if len(entries) != tc.entries {
+			t.Errorf("minute %d: expected %d log entries, got %d", tc.minute, tc.entries, len(entries))
+		}
+		if on := hw.PinState(4).High; on != tc.on {
+			t.Errorf("minute %d: expected the air pump on to be %v", tc.minute, tc.on)
+		}
+	}
+
+	if _, err := newRuleEngine([]Rule{{
+		Name: "unknown",
+		All:  []Condition{{Sensor: "co2", Above: &above}},
+		Then: []Action{{Device: consts.AirPump, Action: "on"}},
+	}}, sensors, devices); err == nil {
+		t.Error("expected an error for a rule on a sensor that is not set")
+	}
+}
+
+func TestRuleHoldsDeviceOff(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	saved := holds
+	holds = &ruleHolds{rules: map[consts.OutputDevice]map[string]bool{}}
+	defer func() { holds = saved }()
+	light := NewSimulatedBH1750(500)
+	hw.Attach(0x23, 1, light)
+
+	sensors, err := newRegistry(nil, []I2CSensor{{Name: "bh1750", Bus: 1, Address: 0x23}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := newDeviceManager([]OutputDevice{{Name: consts.AirPump, Pin: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	below := 100.0
+	engine, err := newRuleEngine([]Rule{{
+		Name: "dark",
+		All:  []Condition{{Sensor: "light", Below: &below}},
+		Then: []Action{{Device: consts.AirPump, Action: "off"}},
+	}}, sensors, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	light.Set(50)
+	engine.evaluate(start)
+	if !holds.held(consts.AirPump) {
+		t.Error("expected the air pump held off while the rule is met")
+	}
+	light.Set(500)
+	engine.evaluate(start.Add(time.Minute))
+	if holds.held(consts.AirPump) {
+		t.Error("expected the hold released once the rule is no longer met")
+	}
+}
+
+func TestRuleEngineSensorFailure(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	var readErr error
+	value := 10.0
+	sensors := &Registry{}
+	sensors.add(&sensor{name: "waterlevel", kind: consts.WaterLevel, read: func() (*float64, error) {
+		if readErr != nil {
+			return nil, readErr
+		}
+		return &value, nil
+	}})
+	devices, err := newDeviceManager([]OutputDevice{{Name: consts.AirPump, Pin: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	below := 20.0
+	engine, err := newRuleEngine([]Rule{{
+		Name: "low",
+		All:  []Condition{{Sensor: "waterlevel", Below: &below}},
+		Then: []Action{{Device: consts.AirPump, Action: "on"}},
+	}}, sensors, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	readErr = fmt.Errorf("no answer from the adc")
+	for i, want := range []string{"was not evaluated", "", ""} {
+		entries := engine.evaluate(start.Add(time.Duration(i) * ruleInterval))
+		if want == "" && len(entries) != 0 || want != "" && (len(entries) != 1 || !strings.Contains(entries[0].Message, want)) {
+			t.Errorf("evaluation %d: expected %q, got %v", i, want, entries)
+		}
+	}
+	readErr = nil
+	entries := engine.evaluate(start.Add(3 * ruleInterval))
+	if len(entries) != 2 || !strings.Contains(entries[0].Message, "evaluated again") || !hw.PinState(4).High {
+		t.Errorf("expected the recovery logged and the rule fired, got %v", entries)
+	}
+}
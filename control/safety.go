@@ -0,0 +1,401 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+// safetyInterval is the time between two checks of the running devices.
+const safetyInterval = 10 * time.Second
+
+// Limit bounds how long a device may run. Every limit is in minutes and a limit left at 0 is not
+// checked.
+type Limit struct {
+	Device          consts.OutputDevice `yaml:"device"`
+	MaxOnTime       int64               `yaml:"maxOnTime"`       // longest the device may run without a break.
+	MinOffTime      int64               `yaml:"minOffTime"`      // shortest break before the device runs again.
+	MaxDailyRuntime int64               `yaml:"maxDailyRuntime"` // most the device may run in a day.
+}
+
+// Interlock keeps a device off while the reading of a sensor, named or given by its kind, is
+// above or below a threshold. An interlock with exclusive set instead keeps the devices listed
+// from running at the same time.
+type Interlock struct {
+	Device    consts.OutputDevice   `yaml:"device"`
+	Sensor    string                `yaml:"sensor"`
+	Above     *float64              `yaml:"above"`
+	Below     *float64              `yaml:"below"`
+	Exclusive []consts.OutputDevice `yaml:"exclusive"`
+}
+
+// SafetySetting is the safety section of the config file.
+type SafetySetting struct {
+	Limits     []Limit     `yaml:"limits"`
+	Interlocks []Interlock `yaml:"interlocks"`
+}
+
+type safetyConfig struct {
+	Safety SafetySetting `yaml:"safety"`
+}
+
+// Safety enforces the limits and interlocks of the safety section. Once running, a device that
+// would break a limit or an interlock cannot be turned on and a running device that breaks one is
+// forced off.
+type Safety struct {
+	limits     map[consts.OutputDevice]Limit
+	interlocks []*interlock
+	devices    *DeviceManager
+
+	mu       sync.Mutex
+	day      time.Time
+	dayStart map[consts.OutputDevice]int64 // runtime of each device at the start of the day.
+	before   map[consts.OutputDevice]int64 // seconds each device ran today before a restart.
+	saved    map[consts.OutputDevice]int64 // the runtime of the day last saved.
+	name     string                        // the file the runtime of the day is saved in, empty when not saved.
+}
+
+// savedRuntime is the runtime file, the seconds each device ran on the day.
+type savedRuntime struct {
+	Day     string                        `json:"day"` // 2006-01-02
+	Runtime map[consts.OutputDevice]int64 `json:"runtime"`
+}
+
+type interlock struct {
+	Interlock
+	sensor Sensor
+}
+
+// guard is the safety every device checks before it is turned on, nil until Safety.Enforce.
+var (
+	guardMu sync.RWMutex
+	guard   *Safety
+)
+
+// NewSafety reads the safety section of the config file. Every sensor and device used has to be
+// in the registry and the device manager.
+func NewSafety(sensors *Registry, devices *DeviceManager) (*Safety, error) {
+	var setting safetyConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	s, err := newSafety(setting.Safety, sensors, devices)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadRuntime(config.RuntimeName, time.Now()); err != nil {
+		fmt.Println("could not load the runtime of the day, the daily runtime counts from now", err)
+	}
+	return s, nil
+}
+
+func newSafety(setting SafetySetting, sensors *Registry, devices *DeviceManager) (*Safety, error) {
+	s := &Safety{
+		limits:   map[consts.OutputDevice]Limit{},
+		devices:  devices,
+		dayStart: map[consts.OutputDevice]int64{},
+		before:   map[consts.OutputDevice]int64{},
+	}
+	for _, l := range setting.Limits {
+		if _, err := devices.Device(l.Device); err != nil {
+			return nil, err
+		}
+		if _, ok := s.limits[l.Device]; ok {
+			return nil, fmt.Errorf("the limits of %s are set more than once", l.Device)
+		}
+		if l.MaxOnTime < 0 || l.MinOffTime < 0 || l.MaxDailyRuntime < 0 {
+			return nil, fmt.Errorf("the limits of %s cannot be negative", l.Device)
+		}
+		s.limits[l.Device] = l
+	}
+	for _, i := range setting.Interlocks {
+		if len(i.Exclusive) > 0 {
+			if i.Device != "" || i.Sensor != "" {
+				return nil, fmt.Errorf("an exclusive interlock cannot have a device or a sensor")
+			}
+			if len(i.Exclusive) < 2 {
+				return nil, fmt.Errorf("an exclusive interlock needs at least two devices")
+			}
+			for _, name := range i.Exclusive {
+				if _, err := devices.Device(name); err != nil {
+					return nil, err
+				}
+			}
+			s.interlocks = append(s.interlocks, &interlock{Interlock: i})
+			continue
+		}
+		if _, err := devices.Device(i.Device); err != nil {
+			return nil, err
+		}
+		if (i.Above == nil) == (i.Below == nil) {
+			return nil, fmt.Errorf("the interlock of %s needs either above or below", i.Device)
+		}
+		sensor, ok := sensors.find(i.Sensor)
+		if !ok {
+			return nil, fmt.Errorf("cannot find the sensor %s for the interlock of %s", i.Sensor, i.Device)
+		}
+		s.interlocks = append(s.interlocks, &interlock{Interlock: i, sensor: sensor})
+	}
+	return s, nil
+}
+
+// Enforce makes every device check the safety before it is turned on.
+func (s *Safety) Enforce() {
+	guardMu.Lock()
+	guard = s
+	guardMu.Unlock()
+}
+
+// Run checks the running devices every 10 seconds until ctx is done. Every device forced off is
+// sent over the entry channel as a failure.
+func (s *Safety) Run(ctx context.Context, entry chan *types.LogEntry) error {
+	ticker := time.NewTicker(safetyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		for _, logEntry := range s.check(time.Now()) {
+			entry <- logEntry
+		}
+	}
+}
+
+// checkSafety returns why the device cannot be turned on, nil when it can. Only the safe state
+// can turn a device on once the controller is shutting down.
+func checkSafety(o OutputDevice) error {
+	if isShuttingDown() && o.reason != ReasonSafety {
+		return fmt.Errorf("%s cannot be turned on while the controller is shutting down", o.Name)
+	}
+	guardMu.RLock()
+	s := guard
+	guardMu.RUnlock()
+	if s == nil {
+		return nil
+	}
+	return s.allow(o.Name, time.Now())
+}
+
+// allow returns why the device cannot be turned on at now.
+func (s *Safety) allow(name consts.OutputDevice, now time.Time) error {
+	state, tracked := tracker.state(name, now)
+	if l, ok := s.limits[name]; ok && tracked {
+		if !state.On && l.MinOffTime > 0 && now.Unix()-state.Since < l.MinOffTime*60 {
+			return fmt.Errorf("%s has to stay off for %v minutes between runs", name, l.MinOffTime)
+		}
+		if l.MaxDailyRuntime > 0 && s.dailyRuntime(state, now) >= l.MaxDailyRuntime*60 {
+			return fmt.Errorf("%s already ran for its %v minutes today", name, l.MaxDailyRuntime)
+		}
+	}
+	for _, i := range s.interlocks {
+		if reason, err := i.blocks(name, now); err != nil || reason != "" {
+			if err != nil {
+				return fmt.Errorf("cannot check the interlock of %s. %v", name, err)
+			}
+			return fmt.Errorf("%s is interlocked, %s", name, reason)
+		}
+	}
+	return nil
+}
+
+// blocks returns why the interlock keeps the device off, empty when it does not.
+func (i *interlock) blocks(name consts.OutputDevice, now time.Time) (string, error) {
+	if len(i.Exclusive) > 0 {
+		if !containsDevice(i.Exclusive, name) {
+			return "", nil
+		}
+		self, _ := tracker.state(name, now)
+		for _, other := range i.Exclusive {
+			if other == name {
+				continue
+			}
+			// of two devices running together only the one turned on last is blocked.
+			if state, ok := tracker.state(other, now); ok && state.On && (!self.On || state.Since <= self.Since) {
+				return fmt.Sprintf("%s is running", other), nil
+			}
+		}
+		return "", nil
+	}
+	if i.Device != name {
+		return "", nil
+	}
+	value, err := i.sensor.Read()
+	if err != nil {
+		return "", err
+	}
+	if i.Above != nil && value > *i.Above {
+		return fmt.Sprintf("%s %v is above %v", i.sensor.Name(), value, *i.Above), nil
+	}
+	if i.Below != nil && value < *i.Below {
+		return fmt.Sprintf("%s %v is below %v", i.sensor.Name(), value, *i.Below), nil
+	}
+	return "", nil
+}
+
+// loadRuntime loads the runtime of the day saved in the file name, and saves it there from then
+// on. A runtime saved on another day is left out.
+func (s *Safety) loadRuntime(name string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+	data, err := config.ReadDataFile(name)
+	if err != nil || data == nil {
+		return err
+	}
+	var saved savedRuntime
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	day, err := time.ParseInLocation("2006-01-02", saved.Day, now.Location())
+	if err != nil {
+		return err
+	}
+	if !sameDay(day, now) {
+		return nil
+	}
+	s.day = day
+	for name, runtime := range saved.Runtime {
+		s.before[name] = runtime
+	}
+	return nil
+}
+
+// saveRuntime saves the runtime of the day of every device when it changed since it was last
+// saved.
+func (s *Safety) saveRuntime(runtime map[consts.OutputDevice]int64, now time.Time) error {
+	if s.name == "" {
+		return nil
+	}
+	changed := len(runtime) != len(s.saved)
+	for name, r := range runtime {
+		if s.saved[name] != r {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	data, err := json.Marshal(savedRuntime{Day: now.Format("2006-01-02"), Runtime: runtime})
+	if err != nil {
+		return err
+	}
+	if err := config.WriteDataFile(s.name, data); err != nil {
+		return err
+	}
+	s.saved = runtime
+	return nil
+}
+
+func sameDay(a, b time.Time) bool {
+	y1, m1, d1 := a.Date()
+	y2, m2, d2 := b.Date()
+	return y1 == y2 && m1 == m2 && d1 == d2
+}
+
+// dailyRuntime returns the seconds the device ran since the start of the day, the runtime saved
+// before a restart included.
+func (s *Safety) dailyRuntime(state types.DeviceState, now time.Time) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !sameDay(s.day, now) {
+		s.day = now
+		s.dayStart = map[consts.OutputDevice]int64{}
+		s.before = map[consts.OutputDevice]int64{}
+	}
+	y2, m2, d2 := now.Date()
+	name := consts.OutputDevice(state.Device)
+	start, ok := s.dayStart[name]
+	if !ok {
+		start = state.Runtime
+		if state.On {
+			// the current run counts from when it started, or from midnight.
+			from := state.Since
+			if midnight := time.Date(y2, m2, d2, 0, 0, 0, 0, now.Location()).Unix(); from < midnight {
+				from = midnight
+			}
+			start -= now.Unix() - from
+		}
+		s.dayStart[name] = start
+	}
+	return s.before[name] + state.Runtime - start
+}
+
+// check forces off every running device that breaks a limit or an interlock.
+func (s *Safety) check(now time.Time) []*types.LogEntry {
+	var entries []*types.LogEntry
+	runtime := map[consts.OutputDevice]int64{}
+	defer func() {
+		if err := s.saveRuntime(runtime, now); err != nil {
+			fmt.Println("could not save the runtime of the day", err)
+		}
+	}()
+	for _, device := range s.devices.Devices() {
+		state, ok := tracker.state(device.Name, now)
+		if !ok {
+			continue
+		}
+		// the daily runtime is started for every device, running or not.
+		daily := s.dailyRuntime(state, now)
+		runtime[device.Name] = daily
+		if !state.On {
+			continue
+		}
+
+		var violations []string
+		if l, ok := s.limits[device.Name]; ok {
+			if l.MaxOnTime > 0 && now.Unix()-state.Since >= l.MaxOnTime*60 {
+				violations = append(violations, fmt.Sprintf("it ran longer than %v minutes", l.MaxOnTime))
+			}
+			if l.MaxDailyRuntime > 0 && daily >= l.MaxDailyRuntime*60 {
+				violations = append(violations, fmt.Sprintf("it ran more than %v minutes today", l.MaxDailyRuntime))
+			}
+		}
+		for _, i := range s.interlocks {
+			reason, err := i.blocks(device.Name, now)
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("the interlock cannot be checked %v", err))
+			} else if reason != "" {
+				violations = append(violations, reason)
+			}
+		}
+		if len(violations) == 0 {
+			continue
+		}
+
+		message := fmt.Sprintf("%s was forced off for safety, %s.", device.Name, strings.Join(violations, ", "))
+		if err := device.With(ReasonSafety).Off(); err != nil {
+			message = fmt.Sprintf("Something went wrong forcing %s off for safety (%s) %v", device.Name, strings.Join(violations, ", "), err)
+		}
+		entries = append(entries, &types.LogEntry{
+			Message: message,
+			Success: false,
+			Time:    now.Unix(),
+			Type:    string(device.Name),
+		})
+	}
+	return entries
+}
+
+func containsDevice(devices []consts.OutputDevice, name consts.OutputDevice) bool {
+	for _, d := range devices {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
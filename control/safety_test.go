@@ -0,0 +1,214 @@
+package control
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+func TestSafety(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	light := NewSimulatedBH1750(500)
+	hw.Attach(0x23, 1, light)
+	saved := tracker
+	tracker = &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	defer func() { tracker = saved }()
+
+	sensors, err := newRegistry(nil, []I2CSensor{{Name: "bh1750", Bus: 1, Address: 0x23}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := newDeviceManager([]OutputDevice{
+		{Name: consts.AirPump, Pin: 4},
+		{Name: consts.PHUpPump, Pins: DriverPins{EN: 13, IN1: 6, IN2: 5}, Rate: 1},
+		{Name: consts.PHDownPump, Pins: DriverPins{EN: 19, IN1: 26, IN2: 12}, Rate: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	below := 100.0
+	safety, err := newSafety(SafetySetting{
+		Limits: []Limit{{Device: consts.AirPump, MaxOnTime: 30, MinOffTime: 5, MaxDailyRuntime: 45}},
+		Interlocks: []Interlock{
+			{Device: consts.AirPump, Sensor: "light", Below: &below},
+			{Exclusive: []consts.OutputDevice{consts.PHUpPump, consts.PHDownPump}},
+		},
+	}, sensors, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	airPump, _ := devices.Device(consts.AirPump)
+	start := time.Date(2026, 10, 18, 8, 0, 0, 0, time.Local)
+	// a device forced off is recorded at the time the test runs, moved here to the time checked.
+	forcedOff := func(at time.Time, runtime int64) {
+		state := tracker.states[consts.AirPump]
+		if state.On || state.Reason != string(ReasonSafety) {
+			t.Fatalf("expected the air pump off for safety, got %+v", state)
+		}
+		state.Since, state.Runtime = at.Unix(), runtime
+	}
+
+	if err := safety.allow(consts.AirPump, start); err != nil {
+		t.Fatalf("expected the air pump to be allowed on, got %v", err)
+	}
+	tracker.record(*airPump, true, 1, start)
+	if entries := safety.check(start.Add(29 * time.Minute)); len(entries) != 0 {
+		t.Errorf("expected no violation before the max on time, got %+v", entries)
+	}
+	// forced off after running 30 minutes without a break.
+	if err := airPump.On(); err != nil {
+		t.Fatal(err)
+	}
+	entries := safety.check(start.Add(30 * time.Minute))
+	if len(entries) != 1 || entries[0].Success || !strings.Contains(entries[0].Message, "longer than 30 minutes") {
+		t.Fatalf("expected the air pump forced off, got %+v", entries)
+	}
+	if hw.PinState(4).High {
+		t.Error("expected the relay pin low after the air pump was forced off")
+	}
+	offAt := start.Add(30 * time.Minute)
+	forcedOff(offAt, 30*60)
+	if err := safety.allow(consts.AirPump, offAt.Add(2*time.Minute)); err == nil {
+		t.Error("expected the air pump to stay off for the min off time")
+	}
+
+	// 30 of the 45 daily minutes are used.
+	on := offAt.Add(5 * time.Minute)
+	if err := safety.allow(consts.AirPump, on); err != nil {
+		t.Fatalf("expected the air pump allowed after its break, got %v", err)
+	}
+	tracker.record(*airPump, true, 1, on)
+	entries = safety.check(on.Add(15 * time.Minute))
+	if len(entries) != 1 || !strings.Contains(entries[0].Message, "45 minutes today") {
+		t.Errorf("expected the air pump forced off for its daily runtime, got %+v", entries)
+	}
+	forcedOff(on.Add(15*time.Minute), 45*60)
+	if err := safety.allow(consts.AirPump, on.Add(30*time.Minute)); err == nil {
+		t.Error("expected the air pump blocked for the rest of the day")
+	}
+	if err := safety.allow(consts.AirPump, start.Add(24*time.Hour)); err != nil {
+		t.Errorf("expected the daily runtime to start again the next day, got %v", err)
+	}
+
+	// the interlock keeps the air pump off in the dark.
+	light.Set(50)
+	if err := safety.allow(consts.AirPump, start.Add(25*time.Hour)); err == nil || !strings.Contains(err.Error(), "below 100") {
+		t.Errorf("expected the air pump interlocked, got %v", err)
+	}
+
+	phUp, _ := devices.Device(consts.PHUpPump)
+	phDown, _ := devices.Device(consts.PHDownPump)
+	tracker.record(*phUp, true, 1, start)
+	if err := safety.allow(consts.PHDownPump, start.Add(time.Second)); err == nil {
+		t.Error("expected the ph pumps to be exclusive")
+	}
+	// the pump turned on last is the one forced off.
+	tracker.record(*phDown, true, 1, start.Add(time.Second))
+	entries = safety.check(start.Add(2 * time.Second))
+	if len(entries) != 1 || entries[0].Type != string(consts.PHDownPump) {
+		t.Errorf("expected only the ph down pump forced off, got %+v", entries)
+	}
+}
+
+func TestChangePWMChecksSafety(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	saved := tracker
+	tracker = &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	defer func() { tracker = saved }()
+	devices, err := newDeviceManager([]OutputDevice{
+		{Name: consts.PHUpPump, Pins: DriverPins{EN: 13, IN1: 6, IN2: 5}, Rate: 1},
+		{Name: consts.PHDownPump, Pins: DriverPins{EN: 19, IN1: 26, IN2: 12}, Rate: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	safety, err := newSafety(SafetySetting{
+		Interlocks: []Interlock{{Exclusive: []consts.OutputDevice{consts.PHUpPump, consts.PHDownPump}}},
+	}, nil, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	safety.Enforce()
+	defer func() {
+		guardMu.Lock()
+		guard = nil
+		guardMu.Unlock()
+	}()
+	phUp, _ := devices.Device(consts.PHUpPump)
+	phDown, _ := devices.Device(consts.PHDownPump)
+
+	if err := phUp.On(); err != nil {
+		t.Fatal(err)
+	}
+	// a running device changes its rate without being checked again.
+	if err := phUp.ChangePWM(0.5); err != nil {
+		t.Errorf("expected the rate of the running ph up pump to change, got %v", err)
+	}
+	if err := phDown.ChangePWM(0.5); err == nil {
+		t.Error("expected the ph down pump kept off by the interlock")
+	}
+	if state, _ := tracker.state(consts.PHDownPump, time.Now()); state.On || hw.PinState(19).DutyCycle != 0 {
+		t.Error("expected the ph down pump left off")
+	}
+}
+
+func TestSafetyRuntimeSaved(t *testing.T) {
+	inTempDir(t)
+	saved := tracker
+	tracker = &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	defer func() { tracker = saved }()
+	devices, err := newDeviceManager([]OutputDevice{{Name: consts.AirPump, Pin: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting := SafetySetting{Limits: []Limit{{Device: consts.AirPump, MaxDailyRuntime: 45}}}
+	safety, err := newSafety(setting, nil, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 18, 8, 0, 0, 0, time.Local)
+	if err := safety.loadRuntime(config.RuntimeName, start); err != nil {
+		t.Fatal(err)
+	}
+	airPump, _ := devices.Device(consts.AirPump)
+	tracker.record(*airPump, false, 0, start)
+	safety.check(start)
+	tracker.record(*airPump, true, 1, start)
+	tracker.record(*airPump, false, 0, start.Add(30*time.Minute))
+	safety.check(start.Add(30 * time.Minute))
+
+	// after a restart the 30 minutes run are still counted.
+	restarted, err := newSafety(setting, nil, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.loadRuntime(config.RuntimeName, start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	on := start.Add(time.Hour)
+	tracker.record(*airPump, true, 1, on)
+	entries := restarted.check(on.Add(15 * time.Minute))
+	if len(entries) != 1 || !strings.Contains(entries[0].Message, "45 minutes today") {
+		t.Errorf("expected the air pump forced off for its daily runtime, got %+v", entries)
+	}
+
+	// the runtime of another day is left out.
+	nextDay, err := newSafety(setting, nil, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nextDay.loadRuntime(config.RuntimeName, start.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := nextDay.allow(consts.AirPump, start.Add(24*time.Hour)); err != nil {
+		t.Errorf("expected the daily runtime to start again the next day, got %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/rpc"
+	"github.com/only1isus/majorProj/types"
+)
+
+// maxPending is the most readings kept while the server cannot be reached. The oldest are
+// dropped past it.
+const maxPending = 1000
+
+// Sampling is the sampling section of the config file.
+type Sampling struct {
+	Jitter     float64 `yaml:"jitter"`     // percent of the interval a reading is moved by at random.
+	BatchSize  int     `yaml:"batchSize"`  // readings committed together.
+	FlushEvery int64   `yaml:"flushEvery"` // seconds a reading waits at most before it is committed.
+}
+
+type samplingConfig struct {
+	Sampling *Sampling `yaml:"sampling"`
+}
+
+var defaultSampling = Sampling{
+	Jitter:     10,
+	BatchSize:  20,
+	FlushEvery: 60,
+}
+
+// Sampler reads every sensor of the registry at the interval set by its every setting, one sensor
+// at a time so the sensors sharing a bus are never read together. Sensors without an interval are
+// not read. Each reading is moved by a random jitter so sensors with the same interval spread
+// out, the sensors of a group keep together, and the readings are committed in batches.
+type Sampler struct {
+	sensors []*sensor
+	setting Sampling
+	random  *rand.Rand
+	commit  func([]types.SensorEntry) error
+	// offsets is the jitter of the current slot of every group of sensors.
+	offsets map[string]offset
+}
+
+type offset struct {
+	slot   time.Time
+	jitter time.Duration
+}
+
+// NewSampler reads the sampling section of the config file, the defaults are used when it is not
+// set.
+func NewSampler(r *Registry) (*Sampler, error) {
+	var setting samplingConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	if setting.Sampling == nil {
+		return newSampler(r, defaultSampling, commitSensorEntries)
+	}
+	return newSampler(r, *setting.Sampling, commitSensorEntries)
+}
+
+func newSampler(r *Registry, setting Sampling, commit func([]types.SensorEntry) error) (*Sampler, error) {
+	if setting.Jitter < 0 || setting.Jitter >= 100 {
+		return nil, fmt.Errorf("the sampling jitter needs to be between 0 and 100")
+	}
+	if setting.BatchSize <= 0 || setting.FlushEvery <= 0 {
+		return nil, fmt.Errorf("the sampling batchSize and flushEvery need to be greater than 0")
+	}
+	s := &Sampler{
+		setting: setting,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		commit:  commit,
+		offsets: map[string]offset{},
+	}
+	for _, sensor := range r.sensors {
+		if sensor.every > 0 {
+			s.sensors = append(s.sensors, sensor)
+		}
+	}
+	return s, nil
+}
+
+// sample is a sensor with its schedule. slot is the time the sensor is due without the jitter.
+type sample struct {
+	*sensor
+	slot, next time.Time
+}
+
+// Run reads the sensors until ctx is done then commits the readings left. Readings that fail,
+// reads that overrun their interval and commits that fail are sent over the entry channel.
+func (s *Sampler) Run(ctx context.Context, entry chan *types.LogEntry) error {
+	if len(s.sensors) == 0 {
+		return nil
+	}
+	start := time.Now()
+	samples := make([]*sample, len(s.sensors))
+	for i, sensor := range s.sensors {
+		samples[i] = &sample{sensor: sensor, slot: start}
+		samples[i].next = s.schedule(samples[i])
+	}
+
+	var pending []types.SensorEntry
+	var oldest time.Time
+	commitFailing := false
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if err := s.commit(pending); err != nil {
+			if len(pending) > maxPending {
+				pending = pending[len(pending)-maxPending:]
+			}
+			// only the first failure is logged until a commit goes through.
+			if !commitFailing {
+				commitFailing = true
+				entry <- &types.LogEntry{
+					Message: fmt.Sprintf("Something went wrong committing %d sensor readings, retrying. %v", len(pending), err),
+					Success: false,
+					Time:    time.Now().Unix(),
+					Type:    "sampler",
+				}
+			}
+			oldest = time.Now()
+			return
+		}
+		pending, commitFailing = nil, false
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		// wake up for the next sensor due or for the pending readings to be committed.
+		wake := samples[0].next
+		for _, smp := range samples[1:] {
+			if smp.next.Before(wake) {
+				wake = smp.next
+			}
+		}
+		if len(pending) > 0 {
+			if deadline := oldest.Add(time.Second * time.Duration(s.setting.FlushEvery)); deadline.Before(wake) {
+				wake = deadline
+			}
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(time.Until(wake))
+		select {
+		case <-ctx.Done():
+			flush()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		for _, smp := range samples {
+			if now.Before(smp.next) {
+				continue
+			}
+			reading, logs := s.read(smp)
+			for _, logEntry := range logs {
+				entry <- logEntry
+			}
+			if reading != nil {
+				if len(pending) == 0 {
+					oldest = time.Now()
+				}
+				pending = append(pending, *reading)
+			}
+			// while the commits fail they are only retried every flushEvery.
+			if len(pending) >= s.setting.BatchSize && !commitFailing {
+				flush()
+			}
+		}
+		if len(pending) > 0 && time.Since(oldest) >= time.Second*time.Duration(s.setting.FlushEvery) {
+			flush()
+		}
+	}
+}
+
+// read reads the sensor and moves it to its next slot, skipping the slots missed while other
+// sensors were read. A read that takes longer than the interval overran and is logged.
+func (s *Sampler) read(smp *sample) (*types.SensorEntry, []*types.LogEntry) {
+	now := time.Now()
+	value, err := smp.Read()
+	done := time.Now()
+
+	var logs []*types.LogEntry
+	if err != nil {
+		logs = append(logs, &types.LogEntry{
+			Message: fmt.Sprintf("Something went wrong reading the %s sensor %v", smp.name, err),
+			Success: false,
+			Time:    done.Unix(),
+			Type:    string(smp.kind),
+		})
+	}
+	smp.slot = smp.slot.Add(smp.every)
+	var missed int64
+	if !done.Before(smp.slot) {
+		missed = int64(done.Sub(smp.slot)/smp.every) + 1
+		smp.slot = smp.slot.Add(time.Duration(missed) * smp.every)
+	}
+	if took := done.Sub(now); took >= smp.every {
+		logs = append(logs, &types.LogEntry{
+			Message: fmt.Sprintf("The %s sensor overran its interval of %v, the read took %v. Skipped %d readings.", smp.name, smp.every, took.Round(time.Millisecond), missed),
+			Success: false,
+			Time:    done.Unix(),
+			Type:    string(smp.kind),
+		})
+	}
+	smp.next = s.schedule(smp)
+
+	if err != nil {
+		return nil, logs
+	}
+	return &types.SensorEntry{SensorType: smp.kind, Time: now.Unix(), Value: value}, logs
+}
+
+// schedule returns the time the sensor is read in its slot. The sensors of a group share the
+// jitter of the slot so they are read one after the other.
+func (s *Sampler) schedule(smp *sample) time.Time {
+	if smp.group == "" {
+		return smp.slot.Add(s.jitter(smp.every))
+	}
+	o, ok := s.offsets[smp.group]
+	if !ok || !o.slot.Equal(smp.slot) {
+		o = offset{slot: smp.slot, jitter: s.jitter(smp.every)}
+		s.offsets[smp.group] = o
+	}
+	return smp.slot.Add(o.jitter)
+}
+
+// jitter returns a random part of the interval, up to the jitter percent of it.
+func (s *Sampler) jitter(every time.Duration) time.Duration {
+	max := int64(float64(every) * s.setting.Jitter / 100)
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(s.random.Int63n(max))
+}
+
+// commitSensorEntries commits the readings to the server in one request.
+func commitSensorEntries(entries []types.SensorEntry) error {
+	out, err := json.Marshal(&types.Sensor{Data: entries})
+	if err != nil {
+		return err
+	}
+	return rpc.CommitSensorData(&out)
+}
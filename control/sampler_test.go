@@ -0,0 +1,79 @@
+package control
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+func TestSampler(t *testing.T) {
+	registry := &Registry{}
+	registry.add(&sensor{name: "fast", kind: consts.Light, every: 10 * time.Millisecond,
+		read: func() (*float64, error) {
+			value := 900.0
+			return &value, nil
+		}})
+	registry.add(&sensor{name: "slow", kind: consts.Light, every: 20 * time.Millisecond,
+		read: func() (*float64, error) {
+			time.Sleep(50 * time.Millisecond)
+			value := 1.0
+			return &value, nil
+		}})
+	registry.add(&sensor{name: "unread", kind: consts.Light})
+
+	var mu sync.Mutex
+	var batches [][]types.SensorEntry
+	sampler, err := newSampler(registry, Sampling{Jitter: 0, BatchSize: 5, FlushEvery: 60}, func(entries []types.SensorEntry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, append([]types.SensorEntry{}, entries...))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sampler.sensors) != 2 {
+		t.Fatalf("expected the sensor without an interval left out, got %d sensors", len(sampler.sensors))
+	}
+
+	entry := make(chan *types.LogEntry, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sampler.Run(ctx, entry)
+		close(done)
+	}()
+	time.Sleep(300 * time.Millisecond)
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	total := 0
+	for i, batch := range batches {
+		if len(batch) > 5 || (len(batch) < 5 && i != len(batches)-1) {
+			t.Errorf("batch %d has %d readings, expected batches of 5 and the rest when stopped", i, len(batch))
+		}
+		total += len(batch)
+	}
+	if total < 5 {
+		t.Errorf("expected the readings to be committed, got %d", total)
+	}
+	overruns := 0
+	for len(entry) > 0 {
+		if e := <-entry; strings.Contains(e.Message, "overran") {
+			if !strings.Contains(e.Message, "slow") {
+				t.Errorf("unexpected overrun %s", e.Message)
+			}
+			overruns++
+		}
+	}
+	if overruns == 0 {
+		t.Error("expected the slow sensor to overrun its interval")
+	}
+}
@@ -0,0 +1,122 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/only1isus/majorProj/types"
+)
+
+// Schedule runs a device for duration minutes every time the cron expression fires, or at each
+// of the times of day listed in at ("15:04"). A device can have several schedules.
+//
+// The device is on whenever any of its schedules is inside a run, so runs that overlap are
+// joined and the device is turned off at the end of the last one. The schedules are checked
+// every minute from the runs that started in the last duration minutes: after a restart a run
+// that is still going is picked up for the minutes it has left, runs that ended while the
+// controller was down are not made up.
+type Schedule struct {
+	Cron     string   `yaml:"cron"`
+	At       []string `yaml:"at"`
+	Duration int64    `yaml:"duration"` // minutes
+}
+
+// compiledSchedule is a schedule with its times parsed.
+type compiledSchedule struct {
+	starts   []*cronExpression
+	duration time.Duration
+}
+
+func (s Schedule) compile() (*compiledSchedule, error) {
+	if s.Duration <= 0 {
+		return nil, fmt.Errorf("a schedule needs a duration greater than 0")
+	}
+	if s.Cron == "" && len(s.At) == 0 {
+		return nil, fmt.Errorf("a schedule needs a cron expression or the times to run at")
+	}
+	c := &compiledSchedule{duration: time.Minute * time.Duration(s.Duration)}
+	if s.Cron != "" {
+		expression, err := parseCron(s.Cron)
+		if err != nil {
+			return nil, err
+		}
+		c.starts = append(c.starts, expression)
+	}
+	for _, at := range s.At {
+		t, err := time.Parse(clockLayout, at)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the time %q, use %s", at, clockLayout)
+		}
+		expression, err := parseCron(fmt.Sprintf("%d %d * * *", t.Minute(), t.Hour()))
+		if err != nil {
+			return nil, err
+		}
+		c.starts = append(c.starts, expression)
+	}
+	return c, nil
+}
+
+// active reports whether a run of the schedule is going on at t.
+func (c *compiledSchedule) active(t time.Time) bool {
+	t = t.Truncate(time.Minute)
+	for back := time.Duration(0); back < c.duration; back += time.Minute {
+		for _, start := range c.starts {
+			if start.matches(t.Add(-back)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scheduledOn reports whether any of the schedules is inside a run at t.
+func scheduledOn(schedules []*compiledSchedule, t time.Time) bool {
+	for _, s := range schedules {
+		if s.active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// FollowSchedules keeps the device on while one of its schedules is running, until ctx is done.
+// The schedules are checked at the start of every minute. Failures to switch the device are sent
+// over the entry channel, the switches themselves are recorded with the schedule reason.
+func (o *OutputDevice) FollowSchedules(ctx context.Context, entry chan *types.LogEntry) error {
+	var schedules []*compiledSchedule
+	for _, s := range o.Schedules {
+		compiled, err := s.compile()
+		if err != nil {
+			return permanent(fmt.Errorf("%s: %v", o.Name, err))
+		}
+		schedules = append(schedules, compiled)
+	}
+
+	device := o.With(ReasonSchedule)
+	var isOn *bool
+	for {
+		now := time.Now()
+		on := scheduledOn(schedules, now)
+		if isOn == nil || *isOn != on {
+			action, switchDevice := "off", device.Off
+			if on {
+				action, switchDevice = "on", device.On
+			}
+			if err := switchDevice(); err != nil {
+				entry <- &types.LogEntry{
+					Message: fmt.Sprintf("Something went wrong turning %s %s %v", o.Name, action, err),
+					Success: false,
+					Time:    now.Unix(),
+					Type:    string(o.Name),
+				}
+			} else {
+				isOn = &on
+			}
+		}
+		// wake up at the start of the next minute.
+		if err := sleep(ctx, now.Truncate(time.Minute).Add(time.Minute).Sub(time.Now())); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	at := func(value string) time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+		return t
+	}
+	tt := []struct {
+		expression string
+		time       string
+		matches    bool
+	}{
+		{expression: "0 * * * *", time: "2026-10-18 14:00", matches: true},
+		{expression: "0 * * * *", time: "2026-10-18 14:01", matches: false},
+		{expression: "*/15 8-18 * * *", time: "2026-10-18 17:45", matches: true},
+		{expression: "*/15 8-18 * * *", time: "2026-10-18 19:00", matches: false},
+		{expression: "30 6 * * 1-5", time: "2026-10-19 06:30", matches: true}, // monday
+		{expression: "30 6 * * 1-5", time: "2026-10-18 06:30", matches: false},
+		{expression: "0 12 * * 7", time: "2026-10-18 12:00", matches: true}, // sunday
+		// either day matches when both are restricted.
+		{expression: "0 0 1 * 1", time: "2026-10-19 00:00", matches: true},
+		{expression: "0 0 1,15 10 *", time: "2026-10-15 00:00", matches: true},
+		// a step over every day is not a restriction, both days have to match.
+		{expression: "0 0 */2 * 1", time: "2026-10-19 00:00", matches: true},
+		{expression: "0 0 */2 * 1", time: "2026-10-26 00:00", matches: false},
+		{expression: "0 0 */2 * 1", time: "2026-10-21 00:00", matches: false},
+	}
+	for _, tc := range tt {
+		c, err := parseCron(tc.expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.matches(at(tc.time)); got != tc.matches {
+			t.Errorf("%q at %s: expected %v, got %v", tc.expression, tc.time, tc.matches, got)
+		}
+	}
+	for _, expression := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(expression); err == nil {
+			t.Errorf("expected an error for %q", expression)
+		}
+	}
+}
+
+func TestScheduledOn(t *testing.T) {
+	at := func(value string) time.Time {
+		t, _ := time.ParseInLocation("15:04", value, time.Local)
+		return t
+	}
+	var schedules []*compiledSchedule
+	for _, s := range []Schedule{
+		{Cron: "0 * * * *", Duration: 15},
+		{At: []string{"08:10", "18:00"}, Duration: 30},
+	} {
+		c, err := s.compile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		schedules = append(schedules, c)
+	}
+	tt := []struct {
+		time string
+		on   bool
+	}{
+		{time: "07:59", on: false},
+		{time: "08:00", on: true},
+		{time: "08:14", on: true},
+		// the runs at 08:00 and 08:10 overlap and end at 08:40.
+		{time: "08:39", on: true},
+		{time: "08:40", on: false},
+		{time: "09:15", on: false},
+		// a restart at 18:20 picks up the last 10 minutes of the 18:00 run.
+		{time: "18:20", on: true},
+		{time: "18:30", on: false},
+	}
+	for _, tc := range tt {
+		if got := scheduledOn(schedules, at(tc.time)); got != tc.on {
+			t.Errorf("at %s: expected %v, got %v", tc.time, tc.on, got)
+		}
+	}
+	if _, err := (Schedule{Cron: "0 * * * *"}).compile(); err == nil {
+		t.Error("expected an error for a schedule without a duration")
+	}
+}
@@ -0,0 +1,314 @@
+package control
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+)
+
+// Sensor is a sensor built from the analogSensor or i2cSensors section of the config file.
+type Sensor interface {
+	// Name is the name of the sensor in the config file.
+	Name() string
+	// Kind is what the sensor measures. Readings are committed under it.
+	Kind() consts.BucketFilter
+	Read() (float64, error)
+	Close() error
+}
+
+// sensor adapts the sensors of the control package to the Sensor interface. Sensors of a group
+// come from one reading of a device and are read together.
+type sensor struct {
+	name   string
+	kind   consts.BucketFilter
+	every  time.Duration
+	group  string
+	device interface{}
+	read   func() (*float64, error)
+	close  func() error
+}
+
+func (s *sensor) Name() string              { return s.name }
+func (s *sensor) Kind() consts.BucketFilter { return s.kind }
+
+func (s *sensor) Read() (float64, error) {
+	value, err := s.read()
+	if err != nil {
+		return 0, err
+	}
+	return *value, nil
+}
+
+func (s *sensor) Close() error {
+	if s.close == nil {
+		return nil
+	}
+	return s.close()
+}
+
+// Registry holds every sensor listed in the config file. The analog sensors share one
+// connection to each ADS1115.
+type Registry struct {
+	sensors []*sensor
+	adcs    map[consts.ADS1115Device]ADC
+}
+
+type sensorsConfig struct {
+	AnalogSensor []ADCSensor `yaml:"analogSensor"`
+	I2CSensors   []I2CSensor `yaml:"i2cSensors"`
+	ADSDevices   []ADSDevice `yaml:"adsDevices"`
+}
+
+// NewRegistry builds every sensor listed under analogSensor and i2cSensors. The type of a sensor
+// is taken from its name unless the type is set, so several sensors of a type can be listed
+// under different names. A sensor that cannot be reached is left out of the registry.
+func NewRegistry() (*Registry, error) {
+	var setting sensorsConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	return newRegistry(setting.AnalogSensor, setting.I2CSensors, setting.ADSDevices)
+}
+
+func newRegistry(analogSensors []ADCSensor, i2cSensors []I2CSensor, adsDevices []ADSDevice) (*Registry, error) {
+	r := &Registry{adcs: map[consts.ADS1115Device]ADC{}}
+	for _, s := range analogSensors {
+		if s.Type == "" {
+			s.Type = s.Name
+		}
+		switch s.Type {
+		case consts.WaterLevelSensor, consts.PHSensor, consts.ECSensor, consts.WaterTemperatureSensor:
+		default:
+			r.Close()
+			return nil, fmt.Errorf("unknown analog sensor type %q for %s", s.Type, s.Name)
+		}
+		connection, err := r.adc(s.ADS, adsDevices)
+		if err != nil {
+			fmt.Printf("skipping the %s sensor. %v\n", s.Name, err)
+			continue
+		}
+		if err := r.addAnalogSensor(s, connection); err != nil {
+			fmt.Printf("skipping the %s sensor. %v\n", s.Name, err)
+		}
+	}
+	for _, s := range i2cSensors {
+		if s.Type == "" {
+			s.Type = consts.I2CSensor(s.Name)
+		}
+		switch s.Type {
+		case consts.Sth3xHumidity, consts.Sth3xTemperature, consts.SHT3x, consts.SCD30, consts.BH1750:
+		default:
+			r.Close()
+			return nil, fmt.Errorf("unknown i2c sensor type %q for %s", s.Type, s.Name)
+		}
+		if err := r.addI2CSensor(s); err != nil {
+			fmt.Printf("skipping the %s sensor. %v\n", s.Name, err)
+		}
+	}
+	// the ph and the ec are read at the temperature of the water.
+	if water, ok := r.find(string(consts.WaterTemperature)); ok {
+		for _, s := range r.sensors {
+			switch device := s.device.(type) {
+			case *PHSensor:
+				device.waterTemperature = water
+			case *ECSensor:
+				device.waterTemperature = water
+			}
+		}
+	}
+	return r, nil
+}
+
+// adc returns the connection to the ADS1115 named, the first of the adsDevices when no name is
+// given.
+func (r *Registry) adc(name consts.ADS1115Device, adsDevices []ADSDevice) (ADC, error) {
+	if len(adsDevices) == 0 {
+		return nil, fmt.Errorf("no adsDevices are set")
+	}
+	if name == "" {
+		name = adsDevices[0].Name
+	}
+	if connection, ok := r.adcs[name]; ok {
+		return connection, nil
+	}
+	for _, device := range adsDevices {
+		if device.Name != name {
+			continue
+		}
+		connection, err := CurrentHardware().OpenADC(device.Address, device.Bus)
+		if err != nil {
+			return nil, err
+		}
+		r.adcs[name] = connection
+		return connection, nil
+	}
+	return nil, fmt.Errorf("cannot find %s in the adsDevices setting", name)
+}
+
+func (r *Registry) addAnalogSensor(s ADCSensor, connection ADC) error {
+	every := time.Minute * time.Duration(s.Every)
+	switch s.Type {
+	case consts.WaterLevelSensor:
+		wl, err := newWaterLevelSensor(s, connection)
+		if err != nil {
+			return err
+		}
+		r.add(&sensor{name: string(s.Name), kind: consts.WaterLevel, every: every, group: string(s.Name), device: wl,
+			read: func() (*float64, error) {
+				level, err := wl.Get()
+				if err != nil {
+					return nil, err
+				}
+				return &level.Percent, nil
+			}})
+		// the volume is only known when the shape of the reservoir is set.
+		if wl.Reservoir.Shape != "" {
+			r.add(&sensor{name: string(s.Name) + "volume", kind: consts.WaterVolume, every: every, group: string(s.Name), device: wl,
+				read: func() (*float64, error) {
+					level, err := wl.Get()
+					if err != nil {
+						return nil, err
+					}
+					return &level.Litres, nil
+				}})
+		}
+	case consts.PHSensor:
+		ph, err := newPHSensor(s, connection)
+		if err != nil {
+			return err
+		}
+		r.add(&sensor{name: string(s.Name), kind: consts.PH, every: every, device: ph, read: ph.Get})
+	case consts.ECSensor:
+		ec, err := newECSensor(s, connection)
+		if err != nil {
+			return err
+		}
+		r.add(&sensor{name: string(s.Name), kind: consts.EC, every: every, device: ec, read: ec.Get})
+	case consts.WaterTemperatureSensor:
+		water, err := newWaterTemperatureSensor(s, connection)
+		if err != nil {
+			return err
+		}
+		r.add(&sensor{name: string(s.Name), kind: consts.WaterTemperature, every: every, device: water, read: water.Get})
+	}
+	return nil
+}
+
+func (r *Registry) addI2CSensor(s I2CSensor) error {
+	every := time.Minute * time.Duration(s.Every)
+	switch s.Type {
+	case consts.Sth3xHumidity:
+		hs := HumiditySensor(s)
+		r.add(&sensor{name: s.Name, kind: consts.Humidity, every: every, device: &hs, read: hs.Get})
+	case consts.Sth3xTemperature:
+		ts := TemperatureSensor(s)
+		r.add(&sensor{name: s.Name, kind: consts.Temperature, every: every, device: &ts, read: ts.Get})
+	case consts.SHT3x:
+		// one reading of the chip gives the temperature, the humidity and the metrics derived
+		// from both, each committed under its own kind.
+		ts, hs, cs := TemperatureSensor(s), HumiditySensor(s), ClimateSensor(s)
+		r.add(&sensor{name: s.Name + "temperature", kind: consts.Temperature, every: every, group: s.Name, device: &ts, read: ts.Get})
+		r.add(&sensor{name: s.Name + "humidity", kind: consts.Humidity, every: every, group: s.Name, device: &hs, read: hs.Get})
+		derived := []struct {
+			kind  consts.BucketFilter
+			value func(*ClimateReading) float64
+		}{
+			{consts.VPD, func(c *ClimateReading) float64 { return c.VPD }},
+			{consts.DewPoint, func(c *ClimateReading) float64 { return c.DewPoint }},
+			{consts.AbsoluteHumidity, func(c *ClimateReading) float64 { return c.AbsoluteHumidity }},
+		}
+		for _, d := range derived {
+			value := d.value
+			r.add(&sensor{name: s.Name + string(d.kind), kind: d.kind, every: every, group: s.Name, device: &cs,
+				read: func() (*float64, error) {
+					reading, err := cs.Get()
+					if err != nil {
+						return nil, err
+					}
+					v := value(reading)
+					return &v, nil
+				}})
+		}
+	case consts.SCD30:
+		co2, err := newCO2Sensor(s)
+		if err != nil {
+			return err
+		}
+		r.add(&sensor{name: s.Name, kind: consts.CO2, every: every, device: co2, read: co2.Get})
+	case consts.BH1750:
+		ls := LightSensor(s)
+		r.add(&sensor{name: s.Name, kind: consts.Light, every: every, device: &ls, read: ls.Get})
+	}
+	return nil
+}
+
+func (r *Registry) add(s *sensor) {
+	r.sensors = append(r.sensors, s)
+}
+
+// Sensors returns every sensor in the registry.
+func (r *Registry) Sensors() []Sensor {
+	sensors := make([]Sensor, len(r.sensors))
+	for i, s := range r.sensors {
+		sensors[i] = s
+	}
+	return sensors
+}
+
+// Get returns the sensor named in the config file.
+func (r *Registry) Get(name string) (Sensor, bool) {
+	for _, s := range r.sensors {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// find returns the sensor named, or the first sensor of the kind when no sensor has the name.
+func (r *Registry) find(nameOrKind string) (Sensor, bool) {
+	if s, ok := r.Get(nameOrKind); ok {
+		return s, true
+	}
+	for _, s := range r.sensors {
+		if string(s.kind) == nameOrKind {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Lookup returns the sensor behind the first sensor of the kind, for example a *PHSensor for
+// consts.PH. It returns nil when no sensor of the kind is set.
+func (r *Registry) Lookup(kind consts.BucketFilter) interface{} {
+	for _, s := range r.sensors {
+		if s.kind == kind {
+			return s.device
+		}
+	}
+	return nil
+}
+
+// Close closes every sensor and the connections to the ADS1115s.
+func (r *Registry) Close() error {
+	var first error
+	for _, s := range r.sensors {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	for _, connection := range r.adcs {
+		if err := connection.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
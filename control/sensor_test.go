@@ -0,0 +1,48 @@
+package control
+
+import (
+	"testing"
+
+	"github.com/only1isus/majorProj/consts"
+)
+
+func TestRegistry(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	hw.Attach(0x23, 1, NewSimulatedBH1750(18500))
+	hw.Attach(0x5c, 1, NewSimulatedBH1750(900))
+
+	registry, err := newRegistry(nil, []I2CSensor{
+		{Name: "bh1750", Bus: 1, Address: 0x23, Every: 5},
+		{Name: "canopy", Type: consts.BH1750, Bus: 1, Address: 0x5c, Every: 5},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer registry.Close()
+
+	if n := len(registry.Sensors()); n != 2 {
+		t.Fatalf("expected 2 sensors, got %d", n)
+	}
+	canopy, ok := registry.Get("canopy")
+	if !ok {
+		t.Fatal("expected to find the canopy sensor")
+	}
+	if canopy.Kind() != consts.Light {
+		t.Errorf("expected the canopy sensor to measure %s, got %s", consts.Light, canopy.Kind())
+	}
+	if lux, err := canopy.Read(); err != nil || lux != 900 {
+		t.Errorf("expected 900 lux, got %v %v", lux, err)
+	}
+	if _, ok := registry.Lookup(consts.Light).(*LightSensor); !ok {
+		t.Error("expected the light sensor behind the first light sensor")
+	}
+
+	if _, err := newRegistry(nil, []I2CSensor{{Name: "unknown", Bus: 1, Address: 0x10}}, nil); err == nil {
+		t.Error("expected an error for a sensor of an unknown type")
+	}
+	if registry, err := newRegistry([]ADCSensor{{Name: consts.PHSensor}}, nil, nil); err != nil || len(registry.Sensors()) != 0 {
+		t.Error("expected an analog sensor without an ADS1115 to be left out")
+	}
+}
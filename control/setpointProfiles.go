@@ -0,0 +1,206 @@
+package control
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+)
+
+// ProfileSwitch is what switches between the day and the night profile.
+type ProfileSwitch string
+
+const (
+	// SwitchByClock uses the day profile from dayFrom until nightFrom.
+	SwitchByClock ProfileSwitch = "clock"
+	// SwitchByGrowLight uses the day profile while the grow light is on.
+	SwitchByGrowLight ProfileSwitch = "growlight"
+)
+
+// SetpointProfile holds the setpoints of the climate controls for the day or the night. A value
+// left out keeps the setting of its control.
+type SetpointProfile struct {
+	Temperature  *float64 `yaml:"temperature"`  // c, the setpoint of the temperature control.
+	Heating      *float64 `yaml:"heating"`      // c, the setpoint of the heater control.
+	HumidityLow  *float64 `yaml:"humidityLow"`  // %, the low of the humidity control.
+	HumidityHigh *float64 `yaml:"humidityHigh"` // %, the high of the humidity control.
+	CO2          *float64 `yaml:"co2"`          // ppm, the setpoint of the co2 control.
+}
+
+// SetpointProfiles is the setpointProfiles section of the config file. After a switch the
+// setpoints move from one profile to the other over ramp minutes.
+type SetpointProfiles struct {
+	SwitchBy  ProfileSwitch   `yaml:"switchBy"`
+	DayFrom   string          `yaml:"dayFrom"`   // 15:04, with switchBy clock.
+	NightFrom string          `yaml:"nightFrom"` // 15:04, with switchBy clock.
+	Ramp      int64           `yaml:"ramp"`
+	Day       SetpointProfile `yaml:"day"`
+	Night     SetpointProfile `yaml:"night"`
+
+	// with switchBy growlight, the profile in use and the time the grow light switched to it.
+	// Switches from before started are not taken.
+	day     bool
+	since   time.Time
+	started time.Time
+}
+
+type setpointProfilesConfig struct {
+	SetpointProfiles *SetpointProfiles `yaml:"setpointProfiles"`
+}
+
+// climateSetpoints are the setpoints of the climate controls at a time.
+type climateSetpoints struct {
+	temperature  float64
+	heating      float64
+	humidityLow  float64
+	humidityHigh float64
+	co2          float64
+}
+
+// NewSetpointProfiles reads the setpointProfiles section of the config file. It returns nil when
+// the section is not set, every control then keeps its own setpoints.
+func NewSetpointProfiles() (*SetpointProfiles, error) {
+	var setting setpointProfilesConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	p := setting.SetpointProfiles
+	if p == nil {
+		return nil, nil
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	if p.SwitchBy == SwitchByGrowLight {
+		photoperiod, err := NewPhotoperiod()
+		if err != nil {
+			return nil, err
+		}
+		p.start(photoperiod, time.Now())
+	}
+	return p, nil
+}
+
+// start picks the profile to begin with. Until the photoperiod switches the grow light after a
+// start the tracker still has the state the light was left in, the photoperiod gives the state
+// it is about to be in. Without a photoperiod the state left is used.
+func (p *SetpointProfiles) start(photoperiod *Photoperiod, now time.Time) {
+	p.started = now.Truncate(time.Second)
+	if photoperiod != nil {
+		if on, err := photoperiod.IsOn(now); err == nil {
+			p.day = on
+			return
+		}
+	}
+	state, _ := tracker.state(consts.GrowLight, now)
+	p.day = state.On
+}
+
+func (p *SetpointProfiles) validate() error {
+	switch p.SwitchBy {
+	case SwitchByClock:
+		w, err := clockWindow(p.DayFrom, p.NightFrom)
+		if err != nil {
+			return fmt.Errorf("setpointProfiles: %v", err)
+		}
+		if w.on == w.off {
+			return fmt.Errorf("setpointProfiles needs a dayFrom different from nightFrom")
+		}
+	case SwitchByGrowLight:
+	default:
+		return fmt.Errorf("unknown setpointProfiles switchBy %q, use %s or %s", p.SwitchBy, SwitchByClock, SwitchByGrowLight)
+	}
+	if p.Ramp < 0 {
+		return fmt.Errorf("setpointProfiles cannot have a negative ramp")
+	}
+	return nil
+}
+
+// at returns the setpoints at now, taking the values a profile leaves out from fallback. During
+// a ramp the setpoints are between the two profiles. A nil SetpointProfiles returns fallback.
+func (p *SetpointProfiles) at(now time.Time, fallback climateSetpoints) climateSetpoints {
+	if p == nil {
+		return fallback
+	}
+	active, previous, since := p.active(now)
+	to := active.resolve(fallback)
+	ramp := time.Minute * time.Duration(p.Ramp)
+	elapsed := now.Sub(since)
+	if since.IsZero() || elapsed < 0 || elapsed >= ramp {
+		return to
+	}
+	from := previous.resolve(fallback)
+	f := float64(elapsed) / float64(ramp)
+	between := func(a, b float64) float64 { return ToFixed(a+(b-a)*f, 1) }
+	return climateSetpoints{
+		temperature:  between(from.temperature, to.temperature),
+		heating:      between(from.heating, to.heating),
+		humidityLow:  between(from.humidityLow, to.humidityLow),
+		humidityHigh: between(from.humidityHigh, to.humidityHigh),
+		co2:          between(from.co2, to.co2),
+	}
+}
+
+// each returns the setpoints of the day and the night profile, only fallback when p is nil. The
+// controls check their settings against each, a ramp stays between the two.
+func (p *SetpointProfiles) each(fallback climateSetpoints) []climateSetpoints {
+	if p == nil {
+		return []climateSetpoints{fallback}
+	}
+	return []climateSetpoints{p.Day.resolve(fallback), p.Night.resolve(fallback)}
+}
+
+// active returns the profile in use at now, the one before it and the time of the switch. The
+// time is zero when the grow light was not switched since the start.
+func (p *SetpointProfiles) active(now time.Time) (SetpointProfile, SetpointProfile, time.Time) {
+	if p.SwitchBy == SwitchByGrowLight {
+		state, ok := tracker.state(consts.GrowLight, now)
+		if since := time.Unix(state.Since, 0); ok && state.On != p.day && !since.Before(p.started) {
+			p.day, p.since = state.On, since
+		}
+		if p.day {
+			return p.Day, p.Night, p.since
+		}
+		return p.Night, p.Day, p.since
+	}
+	w, _ := clockWindow(p.DayFrom, p.NightFrom)
+	if w.contains(now) {
+		return p.Day, p.Night, lastClockTime(now, w.on)
+	}
+	return p.Night, p.Day, lastClockTime(now, w.off)
+}
+
+// lastClockTime returns the last time, at or before now, the clock read minute after midnight.
+func lastClockTime(now time.Time, minute int) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), minute/60, minute%60, 0, 0, now.Location())
+	if t.After(now) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+func (s SetpointProfile) resolve(fallback climateSetpoints) climateSetpoints {
+	if s.Temperature != nil {
+		fallback.temperature = *s.Temperature
+	}
+	if s.Heating != nil {
+		fallback.heating = *s.Heating
+	}
+	if s.HumidityLow != nil {
+		fallback.humidityLow = *s.HumidityLow
+	}
+	if s.HumidityHigh != nil {
+		fallback.humidityHigh = *s.HumidityHigh
+	}
+	if s.CO2 != nil {
+		fallback.co2 = *s.CO2
+	}
+	return fallback
+}
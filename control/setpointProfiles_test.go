@@ -0,0 +1,118 @@
+package control
+
+import (
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+func TestSetpointProfilesByClock(t *testing.T) {
+	day, night := 28.0, 24.0
+	low := 50.0
+	dayCO2, nightCO2 := 1000.0, 400.0
+	p := &SetpointProfiles{
+		SwitchBy:  SwitchByClock,
+		DayFrom:   "06:00",
+		NightFrom: "22:00",
+		Ramp:      30,
+		Day:       SetpointProfile{Temperature: &day, CO2: &dayCO2},
+		Night:     SetpointProfile{Temperature: &night, HumidityLow: &low, CO2: &nightCO2},
+	}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	fallback := climateSetpoints{temperature: 26, humidityLow: 55, co2: 800}
+	for _, c := range []struct {
+		at   string
+		want climateSetpoints
+	}{
+		{"12:00", climateSetpoints{temperature: 28, humidityLow: 55, co2: 1000}},
+		{"22:15", climateSetpoints{temperature: 26, humidityLow: 52.5, co2: 700}},
+		{"03:00", climateSetpoints{temperature: 24, humidityLow: 50, co2: 400}},
+		{"06:10", climateSetpoints{temperature: 25.3, humidityLow: 51.7, co2: 600}},
+	} {
+		clock, _ := time.Parse(clockLayout, c.at)
+		now := time.Date(2026, 10, 18, clock.Hour(), clock.Minute(), 0, 0, time.Local)
+		if got := p.at(now, fallback); got != c.want {
+			t.Errorf("at %s expected %+v, got %+v", c.at, c.want, got)
+		}
+	}
+
+	var none *SetpointProfiles
+	if got := none.at(time.Now(), fallback); got != fallback {
+		t.Errorf("expected the fallback without profiles, got %+v", got)
+	}
+	for _, p := range []SetpointProfiles{
+		{SwitchBy: "sun"},
+		{SwitchBy: SwitchByClock, DayFrom: "06:00", NightFrom: "06:00"},
+		{SwitchBy: SwitchByGrowLight, Ramp: -1},
+	} {
+		if err := p.validate(); err == nil {
+			t.Errorf("expected an error for %+v", p)
+		}
+	}
+}
+
+func TestSetpointProfilesByGrowLight(t *testing.T) {
+	saved := tracker
+	tracker = &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	defer func() { tracker = saved }()
+
+	day, night := 18.0, 16.0
+	p := &SetpointProfiles{SwitchBy: SwitchByGrowLight, Ramp: 20, Day: SetpointProfile{Heating: &day}, Night: SetpointProfile{Heating: &night}}
+	start := time.Now()
+	if got := p.at(start, climateSetpoints{}); got.heating != 16 {
+		t.Errorf("expected the night profile before the grow light was switched, got %+v", got)
+	}
+	tracker.record(OutputDevice{Name: consts.GrowLight, Pin: 21}, true, 1, start)
+	if got := p.at(start.Add(5*time.Minute), climateSetpoints{}); got.heating != 16.5 {
+		t.Errorf("expected the heating setpoint ramping to the day, got %+v", got)
+	}
+	if got := p.at(start.Add(time.Hour), climateSetpoints{}); got.heating != 18 {
+		t.Errorf("expected the day profile with the grow light on, got %+v", got)
+	}
+
+	// the night lets the heater stop too close to the night temperature of the fan.
+	temperature := 24.0
+	night = 23
+	p.Night.Temperature = &temperature
+	sensors := &Registry{}
+	sensors.add(&sensor{name: "temperature", kind: consts.Temperature})
+	devices, err := newDeviceManager([]OutputDevice{{Name: consts.Heater, Pin: 23, Rate: 1, Automatic: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newHeater(HeaterControl{Setpoint: 18, Hysteresis: 1.5, MaxDutyCycle: 0.5, Window: 60, Every: 30}, 28, p, sensors, devices); err == nil {
+		t.Error("expected an error for a night heating setpoint running against the fan")
+	}
+}
+
+func TestSetpointProfilesStartWithPhotoperiod(t *testing.T) {
+	saved := tracker
+	tracker = &deviceTracker{states: map[consts.OutputDevice]*types.DeviceState{}}
+	defer func() { tracker = saved }()
+
+	day, night := 18.0, 16.0
+	p := &SetpointProfiles{SwitchBy: SwitchByGrowLight, Ramp: 20, Day: SetpointProfile{Heating: &day}, Night: SetpointProfile{Heating: &night}}
+	light := OutputDevice{Name: consts.GrowLight, Pin: 21}
+	noon := time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)
+	// the light was left off at the last shutdown.
+	tracker.record(light, false, 0, noon.Add(-time.Hour))
+	p.start(&Photoperiod{On: "06:00", Off: "22:00"}, noon)
+
+	if got := p.at(noon, climateSetpoints{}); got.heating != 18 {
+		t.Errorf("expected the day profile of the photoperiod before the grow light is switched, got %+v", got)
+	}
+	// the photoperiod turns the light on, the day goes on without a ramp.
+	tracker.record(light, true, 1, noon.Add(5*time.Second))
+	if got := p.at(noon.Add(time.Minute), climateSetpoints{}); got.heating != 18 {
+		t.Errorf("expected the day profile once the grow light is on, got %+v", got)
+	}
+	evening := time.Date(2026, 10, 18, 22, 0, 0, 0, time.Local)
+	tracker.record(light, false, 0, evening)
+	if got := p.at(evening.Add(5*time.Minute), climateSetpoints{}); got.heating != 17.5 {
+		t.Errorf("expected the heating setpoint ramping to the night, got %+v", got)
+	}
+}
@@ -0,0 +1,86 @@
+package control
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// single shot measurement, low repeatability and no clock stretching.
+var sht3xMeasureCommand = []byte{0x24, 0x16}
+
+// readSHT3x triggers a measurement on the SHT3x and returns the temperature (c) and the
+// relative humidity (%).
+func readSHT3x(device I2CDevice) (float64, float64, error) {
+	if _, err := device.WriteBytes(sht3xMeasureCommand); err != nil {
+		return 0, 0, err
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	buf := make([]byte, 6)
+	if _, err := device.ReadBytes(buf); err != nil {
+		return 0, 0, err
+	}
+	if sht3xCRC(buf[0:2]) != buf[2] || sht3xCRC(buf[3:5]) != buf[5] {
+		return 0, 0, fmt.Errorf("sht3x checksum mismatch")
+	}
+	rawTemperature := uint16(buf[0])<<8 | uint16(buf[1])
+	rawHumidity := uint16(buf[3])<<8 | uint16(buf[4])
+	temperature := -45 + 175*float64(rawTemperature)/65535
+	humidity := 100 * float64(rawHumidity) / 65535
+	return temperature, humidity, nil
+}
+
+// sht3xCRC is the CRC-8 used by the SHT3x, polynomial 0x31 with an initial value of 0xff.
+func sht3xCRC(data []byte) byte {
+	crc := byte(0xff)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x31
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// SimulatedSHT3x answers measurement commands with the temperature and humidity it was last set to.
+type SimulatedSHT3x struct {
+	mu          sync.Mutex
+	temperature float64
+	humidity    float64
+}
+
+// NewSimulatedSHT3x returns a simulated SHT3x reading the temperature (c) and humidity (%) given.
+func NewSimulatedSHT3x(temperature, humidity float64) *SimulatedSHT3x {
+	return &SimulatedSHT3x{temperature: temperature, humidity: humidity}
+}
+
+// Set changes the temperature (c) and humidity (%) returned by the next measurement.
+func (s *SimulatedSHT3x) Set(temperature, humidity float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.temperature = temperature
+	s.humidity = humidity
+}
+
+// WriteBytes accepts any command. Only measurements are simulated.
+func (s *SimulatedSHT3x) WriteBytes(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+// ReadBytes fills buf with a measurement in the format sent by the sensor.
+func (s *SimulatedSHT3x) ReadBytes(buf []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rawTemperature := uint16(math.Round(math.Max(0, math.Min(65535, (s.temperature+45)*65535/175))))
+	rawHumidity := uint16(math.Round(math.Max(0, math.Min(65535, s.humidity*65535/100))))
+	out := []byte{byte(rawTemperature >> 8), byte(rawTemperature), 0, byte(rawHumidity >> 8), byte(rawHumidity), 0}
+	out[2] = sht3xCRC(out[0:2])
+	out[5] = sht3xCRC(out[3:5])
+	return copy(buf, out), nil
+}
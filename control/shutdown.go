@@ -0,0 +1,151 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/only1isus/majorProj/rpc"
+	"github.com/only1isus/majorProj/types"
+)
+
+// Shutdown drives every device to its safe state once, on SIGINT, SIGTERM or a panic recovered
+// by RecoverPanic, then closes what was added with AtShutdown and commits the termination log.
+type Shutdown struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	devices *DeviceManager
+	started time.Time
+	closers []func() error
+	once    sync.Once
+	done    chan struct{}
+}
+
+var (
+	panicMu      sync.Mutex
+	panicHandler func(v interface{})
+	// shuttingDown is set once the devices are driven to their safe state. From then on only
+	// the shutdown can turn a device on.
+	shuttingDown int32
+)
+
+// NewShutdown creates the shutdown of the devices.
+func NewShutdown(devices *DeviceManager) *Shutdown {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Shutdown{ctx: ctx, cancel: cancel, devices: devices, started: time.Now(), done: make(chan struct{})}
+}
+
+// Context is done as soon as the shutdown starts, the loops running under it stop while the
+// devices are driven to their safe state.
+func (s *Shutdown) Context() context.Context {
+	return s.ctx
+}
+
+// AtShutdown adds fn to the functions run after the devices are in their safe state, closing the
+// sensors for example.
+func (s *Shutdown) AtShutdown(fn func() error) {
+	s.closers = append(s.closers, fn)
+}
+
+// Listen shuts down on SIGINT and SIGTERM and on a panic recovered by RecoverPanic. The process
+// exits after a panic, Wait returns after a signal.
+func (s *Shutdown) Listen() {
+	panicMu.Lock()
+	panicHandler = func(v interface{}) {
+		log.Printf("panic: %v\n%s", v, debug.Stack())
+		s.Run(fmt.Sprintf("a panic (%v)", v), false)
+		os.Exit(2)
+	}
+	panicMu.Unlock()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		s.Run(fmt.Sprintf("the %v signal", sig), true)
+	}()
+}
+
+// Wait blocks until the shutdown is done.
+func (s *Shutdown) Wait() {
+	<-s.done
+}
+
+// Run drives the devices to their safe state, runs the functions added with AtShutdown and
+// commits the termination log. Only the first call does anything, the others wait for it.
+func (s *Shutdown) Run(cause string, success bool) {
+	s.once.Do(func() {
+		defer close(s.done)
+		log.Println("cleaning up")
+		atomic.StoreInt32(&shuttingDown, 1)
+		s.cancel()
+		var failures []string
+		if err := s.devices.SafeState(); err != nil {
+			log.Println("cannot put the devices in their safe state", err)
+			failures = append(failures, err.Error())
+		}
+		for _, fn := range s.closers {
+			if err := fn(); err != nil {
+				log.Println(err)
+			}
+		}
+
+		now := time.Now()
+		message := fmt.Sprintf("System terminated by %s at %v on %v. On time %v minutes.", cause, now.Format("15:04:05"), now.Format("2006-01-02"), int64(now.Sub(s.started).Minutes()))
+		if len(failures) > 0 {
+			message = fmt.Sprintf("%s Failed to put the devices in their safe state: %v", message, failures)
+		}
+		out, err := json.Marshal(types.LogEntry{
+			Message: message,
+			Success: success && len(failures) == 0,
+			Time:    now.Unix(),
+			Type:    "termination",
+		})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if err := rpc.CommitLog(&out); err != nil {
+			log.Println("got an error from the commit log ", err)
+		}
+		log.Println("Shutting down")
+	})
+	<-s.done
+}
+
+// RecoverPanic is deferred at the start of every long running goroutine so a panic shuts the
+// controller down with the devices in their safe state. The panic goes on as usual when no
+// Shutdown is listening.
+func RecoverPanic() {
+	v := recover()
+	if v == nil {
+		return
+	}
+	panicMu.Lock()
+	handler := panicHandler
+	panicMu.Unlock()
+	if handler == nil {
+		panic(v)
+	}
+	handler(v)
+}
+
+// Go runs fn in a goroutine that shuts the controller down if fn panics.
+func Go(fn func()) {
+	go func() {
+		defer RecoverPanic()
+		fn()
+	}()
+}
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
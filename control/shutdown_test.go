@@ -0,0 +1,44 @@
+package control
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/types"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	recovered := make(chan interface{}, 1)
+	panicMu.Lock()
+	panicHandler = func(v interface{}) { recovered <- v }
+	panicMu.Unlock()
+	defer func() {
+		panicMu.Lock()
+		panicHandler = nil
+		panicMu.Unlock()
+	}()
+
+	Go(func() { panic("pump stuck") })
+	if v := <-recovered; v != "pump stuck" {
+		t.Errorf("expected the panic to reach the handler, got %v", v)
+	}
+}
+
+func TestSupervisorPanicDoesNotWaitForItself(t *testing.T) {
+	s := NewSupervisor(make(chan *types.LogEntry, 1))
+	waited := make(chan error, 1)
+	panicMu.Lock()
+	panicHandler = func(v interface{}) { waited <- s.Wait(time.Second) }
+	panicMu.Unlock()
+	defer func() {
+		panicMu.Lock()
+		panicHandler = nil
+		panicMu.Unlock()
+	}()
+
+	s.Go(context.Background(), "stuck", func(ctx context.Context) error { panic("pump stuck") })
+	if err := <-waited; err != nil {
+		t.Errorf("expected the shutdown not to wait for the task that panicked, got %v", err)
+	}
+}
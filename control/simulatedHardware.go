@@ -0,0 +1,249 @@
+package control
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SimulatedHardware is a hardware backend that keeps every pin, I2C device and ADC channel in
+// memory. It lets the controller run on machines without a GPIO header.
+type SimulatedHardware struct {
+	mu      sync.Mutex
+	pins    map[uint8]*SimulatedPinState
+	pwm     bool
+	devices map[busAddress]SimulatedI2CDevice
+	adcs    map[busAddress]*SimulatedADC
+}
+
+type busAddress struct {
+	address int
+	bus     int
+}
+
+// SimulatedPinState is the last state written to a simulated pin.
+type SimulatedPinState struct {
+	Mode      string  // "output", "pwm" or "input"
+	High      bool    // level of the pin when used as an output or an input.
+	DutyCycle float64 // 0 - 1 when used as a pwm pin.
+	Freq      int
+
+	driven bool // set once SetInput drove the pin.
+}
+
+// SimulatedI2CDevice is a device attached to the simulated I2C bus.
+type SimulatedI2CDevice interface {
+	WriteBytes(buf []byte) (int, error)
+	ReadBytes(buf []byte) (int, error)
+}
+
+// NewSimulatedHardware returns a simulated backend with an SHT3x attached at 0x44 on bus 1.
+func NewSimulatedHardware() *SimulatedHardware {
+	s := &SimulatedHardware{
+		pins:    map[uint8]*SimulatedPinState{},
+		devices: map[busAddress]SimulatedI2CDevice{},
+		adcs:    map[busAddress]*SimulatedADC{},
+	}
+	s.Attach(0x44, 1, NewSimulatedSHT3x(22, 55))
+	return s
+}
+
+// Attach connects a simulated device at address on the given bus.
+func (s *SimulatedHardware) Attach(address uint8, bus int, device SimulatedI2CDevice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[busAddress{address: int(address), bus: bus}] = device
+}
+
+// Device returns the simulated device attached at address on the given bus.
+func (s *SimulatedHardware) Device(address uint8, bus int) SimulatedI2CDevice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.devices[busAddress{address: int(address), bus: bus}]
+}
+
+// ADC returns the simulated ADC at address on the given bus, creating it if needed.
+func (s *SimulatedHardware) ADC(address, bus int) *SimulatedADC {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := busAddress{address: address, bus: bus}
+	adc, ok := s.adcs[key]
+	if !ok {
+		adc = &SimulatedADC{values: map[int]float64{}}
+		s.adcs[key] = adc
+	}
+	return adc
+}
+
+// PinState returns a copy of the state of the pin.
+func (s *SimulatedHardware) PinState(number uint8) SimulatedPinState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok := s.pins[number]; ok {
+		return *state
+	}
+	return SimulatedPinState{}
+}
+
+// OpenGPIO returns the simulated GPIO header.
+func (s *SimulatedHardware) OpenGPIO() (GPIO, error) {
+	return simulatedGPIO{hw: s}, nil
+}
+
+// OpenI2C returns the device attached at address on the given bus.
+func (s *SimulatedHardware) OpenI2C(address uint8, bus int) (I2CDevice, error) {
+	device := s.Device(address, bus)
+	if device == nil {
+		return nil, fmt.Errorf("no simulated device at address %#x on bus %d", address, bus)
+	}
+	return simulatedI2CConn{device}, nil
+}
+
+// OpenADC returns the simulated ADC at address on the given bus.
+func (s *SimulatedHardware) OpenADC(address, bus int) (ADC, error) {
+	return s.ADC(address, bus), nil
+}
+
+// SetInput drives an input pin to the level given, a float switch for example.
+func (s *SimulatedHardware) SetInput(number uint8, high bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.pin(number)
+	state.High, state.driven = high, true
+}
+
+func (s *SimulatedHardware) pin(number uint8) *SimulatedPinState {
+	state, ok := s.pins[number]
+	if !ok {
+		state = &SimulatedPinState{}
+		s.pins[number] = state
+	}
+	return state
+}
+
+type simulatedGPIO struct {
+	hw *SimulatedHardware
+}
+
+func (g simulatedGPIO) Pin(number uint8) Pin {
+	return simulatedPin{hw: g.hw, number: number}
+}
+
+func (g simulatedGPIO) StartPwm() {
+	g.hw.mu.Lock()
+	defer g.hw.mu.Unlock()
+	g.hw.pwm = true
+}
+
+// StopPwm stops the pwm clock. Like on the pi, pwm pins stop driving their load.
+func (g simulatedGPIO) StopPwm() {
+	g.hw.mu.Lock()
+	defer g.hw.mu.Unlock()
+	g.hw.pwm = false
+	for _, state := range g.hw.pins {
+		if state.Mode == "pwm" {
+			state.DutyCycle = 0
+		}
+	}
+}
+
+func (g simulatedGPIO) Close() error {
+	return nil
+}
+
+type simulatedPin struct {
+	hw     *SimulatedHardware
+	number uint8
+}
+
+func (p simulatedPin) set(update func(*SimulatedPinState)) {
+	p.hw.mu.Lock()
+	defer p.hw.mu.Unlock()
+	update(p.hw.pin(p.number))
+}
+
+func (p simulatedPin) Output() {
+	p.set(func(s *SimulatedPinState) { s.Mode = "output" })
+}
+
+func (p simulatedPin) Pwm() {
+	p.set(func(s *SimulatedPinState) { s.Mode = "pwm" })
+}
+
+func (p simulatedPin) High() {
+	p.set(func(s *SimulatedPinState) {
+		s.High = true
+		s.DutyCycle = 1
+	})
+}
+
+func (p simulatedPin) Low() {
+	p.set(func(s *SimulatedPinState) {
+		s.High = false
+		s.DutyCycle = 0
+	})
+}
+
+func (p simulatedPin) Input() {
+	p.set(func(s *SimulatedPinState) { s.Mode = "input" })
+}
+
+// PullUp makes an input read high until SetInput drives it, like an open switch.
+func (p simulatedPin) PullUp() {
+	p.set(func(s *SimulatedPinState) {
+		if !s.driven {
+			s.High = true
+		}
+	})
+}
+
+func (p simulatedPin) Read() bool {
+	p.hw.mu.Lock()
+	defer p.hw.mu.Unlock()
+	return p.hw.pin(p.number).High
+}
+
+func (p simulatedPin) Freq(freq int) {
+	p.set(func(s *SimulatedPinState) { s.Freq = freq })
+}
+
+func (p simulatedPin) DutyCycle(dutyLen, cycleLen uint32) {
+	p.set(func(s *SimulatedPinState) {
+		if cycleLen == 0 {
+			s.DutyCycle = 0
+			return
+		}
+		s.DutyCycle = float64(dutyLen) / float64(cycleLen)
+	})
+}
+
+type simulatedI2CConn struct {
+	SimulatedI2CDevice
+}
+
+func (simulatedI2CConn) Close() error {
+	return nil
+}
+
+// SimulatedADC is an ADC whose channel values are set by the caller.
+type SimulatedADC struct {
+	mu     sync.Mutex
+	values map[int]float64
+}
+
+// Set changes the value read from the channel.
+func (a *SimulatedADC) Set(channel int, value float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.values[channel] = value
+}
+
+func (a *SimulatedADC) Read(channel int) (float64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.values[channel], nil
+}
+
+// Close does nothing as the simulated ADC is shared by every sensor using it.
+func (a *SimulatedADC) Close() error {
+	return nil
+}
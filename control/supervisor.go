@@ -0,0 +1,125 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/only1isus/majorProj/types"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// Task is a long running loop of the controller. It runs until ctx is done, returning the error
+// of ctx, or until it fails.
+type Task func(ctx context.Context) error
+
+// Supervisor runs the tasks of the controller and restarts the ones that fail. The wait before a
+// restart doubles with every failure in a row, from a second up to 5 minutes, and goes back to a
+// second once the task ran for 5 minutes without failing. A task failing with an error that
+// cannot be fixed by a restart, a wrong setting for example, is not restarted.
+type Supervisor struct {
+	entry      chan *types.LogEntry
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	wg         sync.WaitGroup
+}
+
+// permanentError is an error a restart cannot fix.
+type permanentError struct {
+	error
+}
+
+// permanent marks err as an error the supervisor does not restart the task for.
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+// NewSupervisor creates a supervisor sending every failure over the entry channel.
+func NewSupervisor(entry chan *types.LogEntry) *Supervisor {
+	return &Supervisor{entry: entry, minBackoff: minBackoff, maxBackoff: maxBackoff}
+}
+
+// Go runs the task in a goroutine until ctx is done.
+func (s *Supervisor) Go(ctx context.Context, name string, task Task) {
+	s.wg.Add(1)
+	go func() {
+		// the task is done before a panic shuts the controller down, the shutdown waits for the
+		// other tasks only.
+		defer RecoverPanic()
+		defer s.wg.Done()
+		backoff := s.minBackoff
+		for {
+			started := time.Now()
+			err := task(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				s.entry <- &types.LogEntry{
+					Message: fmt.Sprintf("The %s loop stopped.", name),
+					Success: true,
+					Time:    time.Now().Unix(),
+					Type:    "supervisor",
+				}
+				return
+			}
+			if _, ok := err.(permanentError); ok {
+				s.entry <- &types.LogEntry{
+					Message: fmt.Sprintf("The %s loop is not running. %v", name, err),
+					Success: false,
+					Time:    time.Now().Unix(),
+					Type:    "supervisor",
+				}
+				return
+			}
+
+			if time.Since(started) >= s.maxBackoff {
+				backoff = s.minBackoff
+			}
+			s.entry <- &types.LogEntry{
+				Message: fmt.Sprintf("Something went wrong running the %s loop, restarting it in %v. %v", name, backoff, err),
+				Success: false,
+				Time:    time.Now().Unix(),
+				Type:    "supervisor",
+			}
+			if sleep(ctx, backoff) != nil {
+				return
+			}
+			if backoff *= 2; backoff > s.maxBackoff {
+				backoff = s.maxBackoff
+			}
+		}
+	}()
+}
+
+// Wait waits for every task to return once ctx is done, for timeout at most.
+func (s *Supervisor) Wait(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("some loops were still running after %v", timeout)
+	}
+}
+
+// sleep waits for d, returning the error of ctx when it is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
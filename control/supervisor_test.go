@@ -0,0 +1,71 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/types"
+)
+
+func TestSupervisor(t *testing.T) {
+	entry := make(chan *types.LogEntry, 20)
+	s := NewSupervisor(entry)
+	s.minBackoff, s.maxBackoff = 10*time.Millisecond, 40*time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// fails three times then runs until ctx is done.
+	started := make(chan time.Time, 10)
+	runs := 0
+	s.Go(ctx, "flaky", func(ctx context.Context) error {
+		started <- time.Now()
+		if runs++; runs <= 3 {
+			return errors.New("sensor unplugged")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	s.Go(ctx, "misconfigured", func(ctx context.Context) error {
+		return permanent(errors.New("every needs to be greater than 0"))
+	})
+
+	var starts []time.Time
+	var restarts, stopped int
+	deadline := time.After(time.Second)
+	for len(starts) < 4 || restarts < 3 || stopped < 1 {
+		select {
+		case at := <-started:
+			starts = append(starts, at)
+		case e := <-entry:
+			switch {
+			case strings.Contains(e.Message, "running the flaky loop, restarting it"):
+				restarts++
+			case strings.Contains(e.Message, "The misconfigured loop is not running"):
+				stopped++
+			default:
+				t.Errorf("unexpected entry %+v", e)
+			}
+		case <-deadline:
+			t.Fatalf("expected 4 starts, 3 restarts and a task stopped, got %d, %d and %d", len(starts), restarts, stopped)
+		}
+	}
+	// the wait doubles after every failure.
+	for i, want := range []time.Duration{10, 20, 40} {
+		if got := starts[i+1].Sub(starts[i]); got < want*time.Millisecond {
+			t.Errorf("expected restart %d after at least %vms, got %v", i+1, want, got)
+		}
+	}
+
+	cancel()
+	if err := s.Wait(time.Second); err != nil {
+		t.Error(err)
+	}
+	select {
+	case e := <-entry:
+		t.Errorf("expected no entry once ctx is done, got %+v", e)
+	default:
+	}
+}
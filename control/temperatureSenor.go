@@ -1,15 +1,14 @@
 package control
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"time"
 
-	"github.com/only1isus/majorProj/rpc"
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
 
-	i2c "github.com/d2r2/go-i2c"
-	sht3x "github.com/d2r2/go-sht3x"
 	"github.com/only1isus/majorProj/consts"
 	"github.com/only1isus/majorProj/types"
 )
@@ -17,113 +16,169 @@ import (
 // TemperatureSensor is a type of the sensor struct
 type TemperatureSensor I2CSensor
 
-// NewTemperatureSensor return a TemperatureSensor struct
+// NewTemperatureSensor return a TemperatureSensor struct. The sht3x or sth3xhumidity setting is
+// used when sth3xtemperature is not set, all are read from the same chip.
 func NewTemperatureSensor() (*TemperatureSensor, error) {
-	temperatureSensor, err := NewI2CSensor(consts.Sth3xHumidity)
+	temperatureSensor, err := NewI2CSensor(consts.Sth3xTemperature)
 	if err != nil {
-		return nil, err
+		if temperatureSensor, err = NewI2CSensor(consts.SHT3x); err != nil {
+			if temperatureSensor, err = NewI2CSensor(consts.Sth3xHumidity); err != nil {
+				return nil, err
+			}
+		}
 	}
 	ts := TemperatureSensor(*temperatureSensor)
 	return &ts, nil
 }
 
-// Get method when called returns the current temperature.
+// Get method when called returns the current temperature. The reading is shared with the other
+// sensors of the chip for a few seconds.
 func (t *TemperatureSensor) Get() (*float64, error) {
 	fmt.Println("reading temperature")
-	i2cconn, err := i2c.NewI2C(t.Address, t.Bus)
+	reading, err := readClimate(I2CSensor(*t), time.Now())
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer i2cconn.Close()
+	return &reading.Temperature, nil
+}
+
+// TemperatureControl is the temperatureControl section of the config file. It holds the
+// settings of the PID loop driving the cooling fan.
+type TemperatureControl struct {
+	Setpoint  float64 `yaml:"setpoint"`
+	Kp        float64 `yaml:"kp"`
+	Ki        float64 `yaml:"ki"`        // per second
+	Kd        float64 `yaml:"kd"`        // seconds
+	OutputMin float64 `yaml:"outputMin"` // the fan is turned off when the output falls to this rate.
+	OutputMax float64 `yaml:"outputMax"`
+	Every     int64   `yaml:"every"` // seconds between updates.
+}
+
+type temperatureControlConfig struct {
+	TemperatureControl TemperatureControl `yaml:"temperatureControl"`
+}
 
-	sensor := sht3x.NewSHT3X()
-	temperature, _, err := sensor.ReadTemperatureAndRelativeHumidity(i2cconn, sht3x.RepeatabilityLow)
+var defaultTemperatureControl = TemperatureControl{
+	Setpoint:  28,
+	Kp:        0.25,
+	Ki:        0.002,
+	Kd:        1,
+	OutputMin: 0.2,
+	OutputMax: 1,
+	Every:     30,
+}
+
+// NewTemperatureControl reads the temperatureControl section of the config file. Missing values
+// are taken from the defaults.
+func NewTemperatureControl() (*TemperatureControl, error) {
+	setting := temperatureControlConfig{TemperatureControl: defaultTemperatureControl}
+	configFile, err := config.ReadConfigFile()
 	if err != nil {
 		return nil, err
 	}
-	tmp := new(float64)
-	*tmp = ToFixed(float64(temperature), 1)
-	return tmp, nil
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	if setting.TemperatureControl.Every <= 0 {
+		return nil, fmt.Errorf("temperatureControl every must be greater than 0")
+	}
+	return &setting.TemperatureControl, nil
 }
 
-// Maintain method tries to keep the temperature at the value passed to the method.
-func (t *TemperatureSensor) Maintain(value float64, f *OutputDevice, notify chan<- []byte) error {
-	go func(n chan<- []byte, fan *OutputDevice) error {
+// Maintain method tries to keep the temperature at the value passed to the method. A PID loop
+// sets the duty cycle of the fan using the gains and limits in the temperatureControl setting.
+// Every decision is sent over notify as a LogEntry. The fan is shared with the humidity control,
+// it runs at the higher of the rates the two ask for, and is kept off while the heater runs. The
+// setpoint profiles replace value when they set a temperature. It runs until ctx is done or the
+// sensor or the fan fails.
+func (t *TemperatureSensor) Maintain(ctx context.Context, value float64, f *OutputDevice, notify chan<- []byte) error {
+	setting, err := NewTemperatureControl()
+	if err != nil {
+		return permanent(err)
+	}
+	profiles, err := NewSetpointProfiles()
+	if err != nil {
+		return permanent(err)
+	}
+	pid := &PID{
+		Setpoint:  value,
+		Kp:        setting.Kp,
+		Ki:        setting.Ki,
+		Kd:        setting.Kd,
+		OutputMin: 0,
+		OutputMax: setting.OutputMax,
+		Reverse:   true,
+	}
 
-		for {
-			temp, err := t.Get()
-			if err != nil {
-				n <- nil
-				return err
-			}
-			val := *temp
-			if val >= value {
-				log.Printf("Turning on fan. Current temperature is %f, limit set to %f", val, value)
-				if err := fan.On(); err != nil {
-					n <- nil
-					return err
-				}
-				onTime := time.Now()
-				for {
-					currentTemp, err := t.Get()
-					if err != nil {
-						n <- nil
-						return err
-					}
-					if value >= *currentTemp {
-						time.Sleep(1 * time.Minute)
-						log.Println("Done")
-						fan.ChangePWM(0.4)
-						time.Sleep(1 * time.Minute)
-						if err := fan.Off(); err != nil {
-							n <- nil
-							return err
-						}
-						msg := types.LogEntry{
-							Message: fmt.Sprintf("Fan was turned on for %v minute(s). Upper limit set to %vc", int64(time.Now().Sub(onTime).Minutes()), value),
-							Success: true,
-							Time:    time.Now().Unix(),
-							Type:    "control",
-						}
-						out, err := json.Marshal(msg)
-						if err != nil {
-							n <- nil
-							return err
-						}
-						n <- out
-						break
-					}
-				}
-			}
-			time.Sleep(30 * time.Second)
-		}
-	}(notify, f)
-	return nil
-}
+	fan := f.With(ReasonControl)
+	// after a restart the fan may still be running from before.
+	state, _ := tracker.state(fan.Name, time.Now())
+	running := state.On
+	last := time.Now()
+	ticker := time.NewTicker(time.Second * time.Duration(setting.Every))
+	defer ticker.Stop()
 
-// Prepare gets the entry ready to be committed to the database
-func (t *TemperatureSensor) ReadAndCommit() error {
 	for {
-		timer := time.NewTimer(time.Minute * time.Duration(t.Every))
-		defer timer.Stop()
-		// wait for the timer to reach its limit
-		<-timer.C
-
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
 		temp, err := t.Get()
 		if err != nil {
 			return err
 		}
-		data := &types.SensorEntry{
-			Time:       time.Now().Unix(),
-			SensorType: consts.Temperature,
-			Value:      *temp,
+		now := time.Now()
+		pid.Setpoint = profiles.at(now, climateSetpoints{temperature: value}).temperature
+		result := pid.Update(*temp, now.Sub(last))
+		last = now
+
+		rate := result.Output
+		if rate <= setting.OutputMin {
+			rate = 0
+		}
+		// the fan would blow the heat away.
+		heating := false
+		if state, _ := tracker.state(consts.Heater, now); state.On {
+			rate, heating = 0, true
 		}
-		out, err := json.Marshal(data)
+		applied, by, err := demands.request(fan, "temperature", rate)
 		if err != nil {
 			return err
 		}
-		if err := rpc.CommitSensorData(&out); err != nil {
+		var action string
+		switch {
+		case applied == 0 && running:
+			action = "turned off"
+		case applied == 0:
+			action = "kept off"
+		case !running:
+			action = fmt.Sprintf("turned on at %.2f", applied)
+		default:
+			action = fmt.Sprintf("set to %.2f", applied)
+		}
+		if by != "" && by != "temperature" {
+			action = fmt.Sprintf("%s by the %s control", action, by)
+		} else if heating {
+			action = fmt.Sprintf("%s while the %s runs", action, consts.Heater)
+		}
+		running = applied > 0
+
+		msg := types.LogEntry{
+			Message: fmt.Sprintf("Temperature is %vc, setpoint %vc. Fan %s (p %.3f, i %.3f, d %.3f).", *temp, pid.Setpoint, action, result.P, result.I, result.D),
+			Success: true,
+			Time:    time.Now().Unix(),
+			Type:    "control",
+		}
+		out, err := json.Marshal(msg)
+		if err != nil {
 			return err
 		}
+		select {
+		case notify <- out:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 }
@@ -0,0 +1,190 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/types"
+)
+
+// TopUp is the topUp section of the config file. The reservoir is filled to the target when the
+// level drops below the refillBelow setting of the reservoir.
+type TopUp struct {
+	Target      float64 `yaml:"target"`      // % to fill the reservoir to.
+	MaxFillTime int64   `yaml:"maxFillTime"` // seconds the valve may stay open for a fill.
+	RiseTimeout int64   `yaml:"riseTimeout"` // seconds the level may stay flat before the fill is stopped.
+	CheckEvery  int64   `yaml:"checkEvery"`  // seconds between level readings while filling.
+	RetryAfter  int64   `yaml:"retryAfter"`  // minutes to wait after a failed fill before trying again.
+}
+
+type topUpConfig struct {
+	TopUp *TopUp `yaml:"topUp"`
+}
+
+// fill is the result of running the top up valve.
+type fill struct {
+	before, after *WaterLevelReading
+	duration      time.Duration
+	err           error
+}
+
+// NewTopUp reads the topUp section of the config file. It returns nil when the section is
+// not set.
+func NewTopUp() (*TopUp, error) {
+	var setting topUpConfig
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	t := setting.TopUp
+	if t == nil {
+		return nil, nil
+	}
+	if t.Target <= 0 || t.Target > 100 {
+		return nil, fmt.Errorf("topUp needs a target between 0 and 100%%")
+	}
+	if t.MaxFillTime <= 0 || t.RiseTimeout <= 0 || t.CheckEvery <= 0 {
+		return nil, fmt.Errorf("topUp needs maxFillTime, riseTimeout and checkEvery greater than 0")
+	}
+	return t, nil
+}
+
+// CheckAndTopUp works like CheckAndNotify but opens the valve to fill the reservoir to the
+// target of the topUp setting when the level is low. Every fill is sent over the entry channel
+// with the volume added. A fill that stops before the target raises an alarm and the top up
+// is paused for retryAfter minutes. It runs until ctx is done, a fill going on is stopped.
+func (wl *WaterLevelSensor) CheckAndTopUp(ctx context.Context, valve *OutputDevice, entry chan *types.LogEntry) error {
+	setting, err := NewTopUp()
+	if err != nil {
+		return permanent(err)
+	}
+	if setting == nil {
+		return permanent(fmt.Errorf("the topUp section is not set"))
+	}
+	if valve == nil {
+		return permanent(fmt.Errorf("the reservoir top up needs the %s device", consts.TopUpValve))
+	}
+	if setting.Target <= wl.Reservoir.RefillBelow {
+		return permanent(fmt.Errorf("the topUp target needs to be above the refillBelow level of %v%%", wl.Reservoir.RefillBelow))
+	}
+	if wl.Every <= 0 {
+		return permanent(fmt.Errorf("the water level sensor needs an every value greater than 0"))
+	}
+
+	for {
+		if err := sleep(ctx, time.Minute*time.Duration(wl.Every)); err != nil {
+			return err
+		}
+
+		level, err := wl.Get()
+		if err != nil {
+			entry <- &types.LogEntry{
+				Message: fmt.Sprintf("Something went wrong reading the water level %v", err),
+				Success: false,
+				Time:    time.Now().Unix(),
+				Type:    string(consts.WaterLevel),
+			}
+			continue
+		}
+		if level.Percent >= wl.Reservoir.RefillBelow {
+			continue
+		}
+
+		f := topUp(ctx, valve, wl.Get, setting.Target,
+			time.Second*time.Duration(setting.MaxFillTime),
+			time.Second*time.Duration(setting.RiseTimeout),
+			time.Second*time.Duration(setting.CheckEvery))
+		entry <- wl.fillEntry(valve, f)
+		if f.err != nil {
+			if err := sleep(ctx, time.Minute*time.Duration(setting.RetryAfter)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// fillEntry describes the fill for the log.
+func (wl *WaterLevelSensor) fillEntry(valve *OutputDevice, f fill) *types.LogEntry {
+	var message string
+	switch {
+	case f.before == nil:
+		message = fmt.Sprintf("Could not top up the reservoir. %v", f.err)
+	case f.after == nil:
+		message = fmt.Sprintf("Stopped topping up the reservoir at %v%% after %v. %v", f.before.Percent, f.duration.Round(time.Second), f.err)
+	default:
+		added := fmt.Sprintf("%v%%", ToFixed(f.after.Percent-f.before.Percent, 1))
+		if wl.Reservoir.Shape != "" {
+			added = fmt.Sprintf("%vL", ToFixed(f.after.Litres-f.before.Litres, 1))
+		}
+		message = fmt.Sprintf("Ran %s for %v, filling the reservoir from %v%% to %v%%. Added %s.", valve.Name, f.duration.Round(time.Second), f.before.Percent, f.after.Percent, added)
+		if f.err != nil {
+			message = fmt.Sprintf("%s %v. Please check the water supply.", message, f.err)
+		}
+	}
+	return &types.LogEntry{
+		Message: message,
+		Success: f.err == nil,
+		Time:    time.Now().Unix(),
+		Type:    string(consts.WaterLevel),
+	}
+}
+
+// topUp opens the valve until the level read reaches the target. The valve is closed when the
+// fill runs longer than maxFill, the level does not rise for riseTimeout or ctx is done.
+func topUp(ctx context.Context, device *OutputDevice, read func() (*WaterLevelReading, error), target float64, maxFill, riseTimeout, interval time.Duration) (f fill) {
+	before, err := read()
+	if err != nil {
+		f.err = err
+		return f
+	}
+	f.before = before
+
+	valve := device.With(ReasonControl)
+	start := time.Now()
+	if err := valve.On(); err != nil {
+		valve.Off()
+		f.err = err
+		return f
+	}
+	defer func() {
+		if err := valve.Off(); err != nil && f.err == nil {
+			f.err = fmt.Errorf("could not close %s. %v", valve.Name, err)
+		}
+		f.duration = time.Since(start)
+	}()
+
+	highest, rose := before.Percent, start
+	for {
+		if err := sleep(ctx, interval); err != nil {
+			f.err = fmt.Errorf("stopped before the reservoir was full. %v", err)
+			return f
+		}
+		level, err := read()
+		if err != nil {
+			f.err = err
+			return f
+		}
+		f.after = level
+		now := time.Now()
+		switch {
+		case level.Percent >= target:
+			return f
+		case now.Sub(start) >= maxFill:
+			f.err = fmt.Errorf("the reservoir did not reach %v%% within %v", target, maxFill)
+			return f
+		case level.Percent > highest:
+			highest, rose = level.Percent, now
+		case now.Sub(rose) >= riseTimeout:
+			f.err = fmt.Errorf("the level did not rise for %v", riseTimeout)
+			return f
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package control
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTopUp(t *testing.T) {
+	hw := NewSimulatedHardware()
+	UseHardware(hw)
+	defer UseHardware(nil)
+	valve := &OutputDevice{Name: "topupvalve", Pins: DriverPins{EN: 18, IN1: 23, IN2: 24}, Rate: 1}
+
+	// the level rises 10% for every reading taken with the valve open.
+	level := 20.0
+	rising := func() (*WaterLevelReading, error) {
+		if hw.PinState(18).DutyCycle > 0 {
+			level += 10
+		}
+		return &WaterLevelReading{Percent: level, Litres: level * 0.72}, nil
+	}
+	f := topUp(context.Background(), valve, rising, 90, time.Second, time.Second, time.Millisecond)
+	if f.err != nil {
+		t.Fatal(f.err)
+	}
+	if f.before.Percent != 20 || f.after.Percent != 90 {
+		t.Errorf("expected a fill from 20%% to 90%%, got %v%% to %v%%", f.before.Percent, f.after.Percent)
+	}
+	if hw.PinState(18).DutyCycle != 0 {
+		t.Error("expected the valve to be closed after the fill")
+	}
+
+	// the supply is empty and the level never rises.
+	flat := func() (*WaterLevelReading, error) {
+		return &WaterLevelReading{Percent: 20}, nil
+	}
+	f = topUp(context.Background(), valve, flat, 90, time.Second, 20*time.Millisecond, time.Millisecond)
+	if f.err == nil {
+		t.Error("expected an alarm when the level does not rise")
+	}
+	if f.duration >= time.Second {
+		t.Errorf("expected the fill to stop after the rise timeout, ran for %v", f.duration)
+	}
+	if hw.PinState(18).DutyCycle != 0 {
+		t.Error("expected the valve to be closed after the alarm")
+	}
+}
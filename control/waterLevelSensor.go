@@ -1,99 +1,208 @@
 package control
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"math"
 	"time"
 
-	"github.com/only1isus/ADS1115"
-
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
 	"github.com/only1isus/majorProj/consts"
-	"github.com/only1isus/majorProj/rpc"
 	"github.com/only1isus/majorProj/types"
 )
 
-type WaterLevelSensor ADCSensor
+// WaterLevelSensor is the level sensor in the reservoir. Its calibration is kept in the
+// calibration file under the name of the sensor.
+type WaterLevelSensor struct {
+	ADCSensor
+	Calibration WaterLevelCalibration
+	Reservoir   Reservoir
+}
+
+// WaterLevelCalibration is the reading of the sensor with the reservoir empty and full. The
+// reading is taken to change linearly with the depth of the water.
+type WaterLevelCalibration struct {
+	Time  int64   `json:"time"`
+	Empty float64 `json:"empty"`
+	Full  float64 `json:"full"`
+}
+
+// Reservoir is the reservoir section of the config file. The dimensions are in cm, height being
+// the depth of the water between the empty and full readings. The volume is only reported when
+// the shape is set.
+type Reservoir struct {
+	Shape       string  `yaml:"shape"` // rectangular or cylinder
+	Length      float64 `yaml:"length"`
+	Width       float64 `yaml:"width"`
+	Diameter    float64 `yaml:"diameter"`
+	Height      float64 `yaml:"height"`
+	RefillBelow float64 `yaml:"refillBelow"` // %
+}
+
+// WaterLevelReading is a reading of the water level sensor.
+type WaterLevelReading struct {
+	Percent float64 // % full
+	Litres  float64 // 0 when the reservoir shape is not set.
+}
+
+type reservoirConfig struct {
+	Reservoir Reservoir `yaml:"reservoir"`
+}
+
+// the range of the ADS1115 reading the sensor the controller was first built with.
+var defaultWaterLevelCalibration = WaterLevelCalibration{Empty: 0, Full: 4}
 
+var defaultReservoir = Reservoir{RefillBelow: 25}
+
+// NewWaterLevelSensor returns the water level sensor from the analogSensor setting read through
+// the ADS1115 at address, using the calibration saved for it.
 func NewWaterLevelSensor(address, bus int) (*WaterLevelSensor, error) {
 	wlSensor, err := NewAnalogSensor(consts.WaterLevelSensor)
 	if err != nil {
 		return nil, err
 	}
-	ads, err := ADS1115.NewConnection(address, bus)
+	ads, err := CurrentHardware().OpenADC(address, bus)
 	if err != nil {
 		return nil, err
 	}
-	wlSensor.connection = ADS1115.NewADS1115Device(ads)
-	wl := WaterLevelSensor(*wlSensor)
-	return &wl, nil
+	return newWaterLevelSensor(*wlSensor, ads)
 }
 
-func (wl *WaterLevelSensor) Get() (*float64, error) {
-	value, err := wl.connection.Read(wl.AnalogPin)
+func newWaterLevelSensor(sensor ADCSensor, connection ADC) (*WaterLevelSensor, error) {
+	sensor.connection = connection
+	setting := reservoirConfig{Reservoir: defaultReservoir}
+	configFile, err := config.ReadConfigFile()
 	if err != nil {
 		return nil, err
 	}
-	out := new(float64)
-	*out = ToFixed(float64(value), 1)
-	return out, nil
-}
-
-// CheckAndNotify takes the level of water as an int and a channel to send responses to.
-// if the level of the water in the container is less than the amount specified (0 - 100%)
-// then a message is sent over the channel
-func (wl *WaterLevelSensor) CheckAndNotify(level float64, entry chan *types.LogEntry) {
-	go func(ent chan *types.LogEntry) {
-		for {
-			currentLevel, err := wl.Get()
-			if err != nil {
-				fmt.Printf("%v", err)
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	if _, err := setting.Reservoir.Capacity(); err != nil {
+		return nil, err
+	}
 
-			}
-			timer := time.NewTimer(time.Minute * time.Duration(wl.Every))
-			defer timer.Stop()
-			<-timer.C
-
-			if err != nil {
-				entry <- &types.LogEntry{
-					Message: fmt.Sprintf("Something went wrong reading the water level %v", err),
-					Success: false,
-					Time:    time.Now().Unix(),
-					Type:    string(consts.WaterLevel),
-				}
-			}
-			if *currentLevel < level {
-				entry <- &types.LogEntry{
-					Message: fmt.Sprintf("The current water level is %v. Please consider refilling.", *currentLevel),
-					Success: true,
-					Time:    time.Now().Unix(),
-					Type:    string(consts.WaterLevel),
-				}
-			}
-		}
-	}(entry)
+	wl := &WaterLevelSensor{
+		ADCSensor:   sensor,
+		Calibration: defaultWaterLevelCalibration,
+		Reservoir:   setting.Reservoir,
+	}
+	if _, err := loadCalibration(string(wl.Name), &wl.Calibration); err != nil {
+		return nil, err
+	}
+	return wl, nil
 }
 
-func (wl WaterLevelSensor) ReadAndNotify() error {
+// Capacity returns the volume of the reservoir in litres, 0 when the shape is not set.
+func (r Reservoir) Capacity() (float64, error) {
+	var area float64
+	switch r.Shape {
+	case "":
+		return 0, nil
+	case "rectangular":
+		area = r.Length * r.Width
+	case "cylinder":
+		area = math.Pi * r.Diameter * r.Diameter / 4
+	default:
+		return 0, fmt.Errorf("unknown reservoir shape %q, use rectangular or cylinder", r.Shape)
+	}
+	if area <= 0 || r.Height <= 0 {
+		return 0, fmt.Errorf("the %s reservoir needs its dimensions set", r.Shape)
+	}
+	// 1000 cm3 in a litre.
+	return area * r.Height / 1000, nil
+}
 
-	voltageValue, err := wl.Get()
+// level converts a reading of the sensor to the water level.
+func (wl *WaterLevelSensor) level(reading float64) (*WaterLevelReading, error) {
+	c := wl.Calibration
+	if c.Full == c.Empty {
+		return nil, fmt.Errorf("the water level sensor reads the same when empty and full, calibrate it again")
+	}
+	percent := math.Max(0, math.Min(100, 100*(reading-c.Empty)/(c.Full-c.Empty)))
+	capacity, err := wl.Reservoir.Capacity()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	data := &types.SensorEntry{
-		SensorType: consts.WaterLevel,
-		Time:       time.Now().Unix(),
-		Value:      *voltageValue,
+	return &WaterLevelReading{
+		Percent: ToFixed(percent, 1),
+		Litres:  ToFixed(capacity*percent/100, 1),
+	}, nil
+}
+
+// Get returns the water level in % full and litres.
+func (wl *WaterLevelSensor) Get() (*WaterLevelReading, error) {
+	value, err := wl.connection.Read(wl.AnalogPin)
+	if err != nil {
+		return nil, err
 	}
-	out, err := json.Marshal(data)
+	return wl.level(value)
+}
+
+// CalibrateEmpty records the reading of the sensor with the reservoir empty.
+func (wl *WaterLevelSensor) CalibrateEmpty() error {
+	return wl.calibrate(func(c *WaterLevelCalibration, reading float64) { c.Empty = reading })
+}
+
+// CalibrateFull records the reading of the sensor with the reservoir full.
+func (wl *WaterLevelSensor) CalibrateFull() error {
+	return wl.calibrate(func(c *WaterLevelCalibration, reading float64) { c.Full = reading })
+}
+
+func (wl *WaterLevelSensor) calibrate(set func(c *WaterLevelCalibration, reading float64)) error {
+	reading, err := wl.connection.Read(wl.AnalogPin)
 	if err != nil {
 		return err
 	}
-	if err := rpc.CommitSensorData(&out); err != nil {
+	c := wl.Calibration
+	set(&c, reading)
+	c.Time = time.Now().Unix()
+	if err := saveCalibration(string(wl.Name), c); err != nil {
 		return err
 	}
+	wl.Calibration = c
 	return nil
 }
 
+// CheckAndNotify takes the level of water (0 - 100%) and a channel to send responses to.
+// if the level of the water in the container is less than the amount specified
+// then a message is sent over the channel. It runs until ctx is done.
+func (wl *WaterLevelSensor) CheckAndNotify(ctx context.Context, level float64, entry chan *types.LogEntry) error {
+	if wl.Every <= 0 {
+		return permanent(fmt.Errorf("the water level sensor needs an every value greater than 0"))
+	}
+	for {
+		if err := sleep(ctx, time.Minute*time.Duration(wl.Every)); err != nil {
+			return err
+		}
+
+		currentLevel, err := wl.Get()
+		if err != nil {
+			entry <- &types.LogEntry{
+				Message: fmt.Sprintf("Something went wrong reading the water level %v", err),
+				Success: false,
+				Time:    time.Now().Unix(),
+				Type:    string(consts.WaterLevel),
+			}
+			continue
+		}
+		if currentLevel.Percent < level {
+			message := fmt.Sprintf("The reservoir is %v%% full. Please consider refilling.", currentLevel.Percent)
+			if wl.Reservoir.Shape != "" {
+				message = fmt.Sprintf("The reservoir is %v%% full (%vL). Please consider refilling.", currentLevel.Percent, currentLevel.Litres)
+			}
+			entry <- &types.LogEntry{
+				Message: message,
+				Success: true,
+				Time:    time.Now().Unix(),
+				Type:    string(consts.WaterLevel),
+			}
+		}
+	}
+}
+
 func (wl *WaterLevelSensor) Close() error {
 	wl.connection.Close()
 	return nil
@@ -0,0 +1,37 @@
+package control
+
+import "testing"
+
+func TestWaterLevel(t *testing.T) {
+	wl := &WaterLevelSensor{
+		Calibration: WaterLevelCalibration{Empty: 0.4, Full: 3.6},
+		Reservoir:   Reservoir{Shape: "rectangular", Length: 60, Width: 40, Height: 30},
+	}
+	tt := []struct {
+		reading, percent, litres float64
+	}{
+		{reading: 0.4, percent: 0, litres: 0},
+		{reading: 2, percent: 50, litres: 36},
+		{reading: 3.6, percent: 100, litres: 72},
+		// readings outside the calibration are clamped.
+		{reading: 0.1, percent: 0, litres: 0},
+		{reading: 3.9, percent: 100, litres: 72},
+	}
+	for _, tc := range tt {
+		level, err := wl.level(tc.reading)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level.Percent != tc.percent || level.Litres != tc.litres {
+			t.Errorf("reading %v: expected %v%% and %vL, got %+v", tc.reading, tc.percent, tc.litres, level)
+		}
+	}
+
+	if _, err := (Reservoir{Shape: "cylinder", Height: 30}).Capacity(); err == nil {
+		t.Error("expected an error for a cylinder without a diameter")
+	}
+	wl.Calibration.Full = wl.Calibration.Empty
+	if _, err := wl.level(1); err == nil {
+		t.Error("expected an error when empty and full read the same")
+	}
+}
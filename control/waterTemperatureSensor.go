@@ -0,0 +1,110 @@
+package control
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+)
+
+// WaterTemperatureSensor is an NTC thermistor in the reservoir connected to the ADS1115. Readings
+// are in c. The ph and ec readings are compensated using it.
+type WaterTemperatureSensor struct {
+	ADCSensor
+	Thermistor Thermistor
+}
+
+// Thermistor is the thermistor section of the config file. The thermistor sits between the
+// analog pin and ground, the series resistor between the pin and the supply.
+type Thermistor struct {
+	Supply         float64 `yaml:"supply"`         // volts across the divider.
+	SeriesResistor float64 `yaml:"seriesResistor"` // ohms
+	Nominal        float64 `yaml:"nominal"`        // ohms at 25c.
+	Beta           float64 `yaml:"beta"`           // k
+}
+
+type thermistorConfig struct {
+	Thermistor Thermistor `yaml:"thermistor"`
+}
+
+// a 10k thermistor with a beta of 3950 and a 10k series resistor on 3.3v.
+var defaultThermistor = Thermistor{
+	Supply:         3.3,
+	SeriesResistor: 10000,
+	Nominal:        10000,
+	Beta:           3950,
+}
+
+// NewThermistor reads the thermistor section of the config file, the defaults are used for every
+// value left out.
+func NewThermistor() (*Thermistor, error) {
+	setting := thermistorConfig{Thermistor: defaultThermistor}
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		fmt.Println("error unmarshalling", err)
+		return nil, err
+	}
+	t := setting.Thermistor
+	if t.Supply <= 0 || t.SeriesResistor <= 0 || t.Nominal <= 0 || t.Beta <= 0 {
+		return nil, fmt.Errorf("the thermistor needs a supply, seriesResistor, nominal and beta greater than 0")
+	}
+	return &t, nil
+}
+
+// Temperature returns the temperature (c) of the thermistor read as voltage volts.
+func (t Thermistor) Temperature(voltage float64) (float64, error) {
+	if voltage <= 0 || voltage >= t.Supply {
+		return 0, fmt.Errorf("the thermistor reads %vv, check its wiring", voltage)
+	}
+	resistance := t.SeriesResistor * voltage / (t.Supply - voltage)
+	kelvin := 1 / (1/298.15 + math.Log(resistance/t.Nominal)/t.Beta)
+	return kelvin - 273.15, nil
+}
+
+// Voltage returns the voltage the thermistor reads at temperature (c).
+func (t Thermistor) Voltage(temperature float64) float64 {
+	resistance := t.Nominal * math.Exp(t.Beta*(1/(temperature+273.15)-1/298.15))
+	return t.Supply * resistance / (resistance + t.SeriesResistor)
+}
+
+// NewWaterTemperatureSensor returns the watertemperature sensor from the analogSensor setting.
+func NewWaterTemperatureSensor(connection ADC) (*WaterTemperatureSensor, error) {
+	sensor, err := NewAnalogSensor(consts.WaterTemperatureSensor)
+	if err != nil {
+		return nil, err
+	}
+	return newWaterTemperatureSensor(*sensor, connection)
+}
+
+func newWaterTemperatureSensor(sensor ADCSensor, connection ADC) (*WaterTemperatureSensor, error) {
+	sensor.connection = connection
+	thermistor, err := NewThermistor()
+	if err != nil {
+		return nil, err
+	}
+	return &WaterTemperatureSensor{ADCSensor: sensor, Thermistor: *thermistor}, nil
+}
+
+// Get returns the temperature of the water.
+func (w *WaterTemperatureSensor) Get() (*float64, error) {
+	voltage, err := w.connection.Read(w.AnalogPin)
+	if err != nil {
+		return nil, err
+	}
+	temperature, err := w.Thermistor.Temperature(voltage)
+	if err != nil {
+		return nil, err
+	}
+	out := new(float64)
+	*out = ToFixed(temperature, 1)
+	return out, nil
+}
+
+func (w *WaterTemperatureSensor) Close() error {
+	return w.connection.Close()
+}
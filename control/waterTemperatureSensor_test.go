@@ -0,0 +1,55 @@
+package control
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/only1isus/majorProj/consts"
+)
+
+func TestThermistor(t *testing.T) {
+	thermistor := defaultThermistor
+	// the divider is at half the supply at 25c.
+	if v := thermistor.Voltage(25); math.Abs(v-1.65) > 1e-9 {
+		t.Errorf("expected 1.65v at 25c, got %v", v)
+	}
+	for _, temperature := range []float64{5, 18.5, 30} {
+		if got, err := thermistor.Temperature(thermistor.Voltage(temperature)); err != nil || math.Abs(got-temperature) > 1e-9 {
+			t.Errorf("expected %vc, got %v %v", temperature, got, err)
+		}
+	}
+	for _, voltage := range []float64{0, 3.3} {
+		if _, err := thermistor.Temperature(voltage); err == nil {
+			t.Errorf("expected an error for a thermistor reading %vv", voltage)
+		}
+	}
+}
+
+func TestPHCompensatedByTheWaterTemperature(t *testing.T) {
+	adc := &SimulatedADC{values: map[int]float64{}}
+	water := &WaterTemperatureSensor{ADCSensor: ADCSensor{AnalogPin: 3, connection: adc}, Thermistor: defaultThermistor}
+	setting := defaultPHCalibrationSetting
+	ph := &PHSensor{
+		ADCSensor:   ADCSensor{AnalogPin: 1, connection: adc},
+		Calibration: PHCalibration{Slope: setting.IdealSlope, Offset: setting.IdealOffset},
+		Setting:     setting,
+	}
+
+	// pH 5.5 in water at 15c.
+	adc.Set(1, setting.IdealOffset+setting.IdealSlope*(5.5-7)*nernstFactor(15))
+	adc.Set(3, defaultThermistor.Voltage(15))
+	if value, err := ph.Get(); err != nil || *value == 5.5 {
+		t.Errorf("expected the waterTemperature setting of 25c without a water temperature sensor, got %v %v", value, err)
+	}
+	ph.waterTemperature = &sensor{name: "watertemperature", kind: consts.WaterTemperature, device: water, read: water.Get}
+	if value, err := ph.Get(); err != nil || *value != 5.5 {
+		t.Errorf("expected pH 5.5 at the water temperature read, got %v %v", value, err)
+	}
+
+	// a broken water temperature sensor does not fall back to the setting.
+	ph.waterTemperature = &sensor{name: "watertemperature", kind: consts.WaterTemperature, read: func() (*float64, error) { return nil, fmt.Errorf("disconnected") }}
+	if _, err := ph.Get(); err == nil {
+		t.Error("expected an error when the water temperature cannot be read")
+	}
+}
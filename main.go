@@ -1,17 +1,21 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/signal"
-	"syscall"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/only1isus/majorProj/consts"
 	"github.com/only1isus/majorProj/control"
 	"github.com/only1isus/majorProj/rpc"
+	"github.com/only1isus/majorProj/simulation"
 	"github.com/only1isus/majorProj/types"
 )
 
@@ -34,77 +38,273 @@ func welcome() {
 	fmt.Println("")
 }
 
+// calibrate runs the calibration named and saves it in the calibration file. The ph probe is
+// read in each of the buffers and the ec probe in each of the reference solutions, at the
+// temperature given.
+func calibrate(name string, sensors *control.Registry, buffers, references string, temperature float64) error {
+	switch name {
+	case "waterlevel-empty", "waterlevel-full":
+		wl, ok := sensors.Lookup(consts.WaterLevel).(*control.WaterLevelSensor)
+		if !ok {
+			return fmt.Errorf("a water level sensor is needed in the analogSensor setting")
+		}
+		if name == "waterlevel-empty" {
+			if err := wl.CalibrateEmpty(); err != nil {
+				return err
+			}
+		} else if err := wl.CalibrateFull(); err != nil {
+			return err
+		}
+		fmt.Printf("Saved the calibration of %s, empty at %v and full at %v.\n", wl.Name, wl.Calibration.Empty, wl.Calibration.Full)
+	case "ph":
+		ph, ok := sensors.Lookup(consts.PH).(*control.PHSensor)
+		if !ok {
+			return fmt.Errorf("a ph sensor is needed in the analogSensor setting")
+		}
+		var points []control.PHBufferPoint
+		stdin := bufio.NewReader(os.Stdin)
+		for _, buffer := range strings.Split(buffers, ",") {
+			pH, err := strconv.ParseFloat(strings.TrimSpace(buffer), 64)
+			if err != nil {
+				return fmt.Errorf("cannot read the buffer %q. %v", buffer, err)
+			}
+			fmt.Printf("Put the probe in the pH %v buffer, wait for the reading to settle and press enter.", pH)
+			if _, err := stdin.ReadString('\n'); err != nil {
+				return err
+			}
+			point, err := ph.ReadBuffer(pH, temperature)
+			if err != nil {
+				return err
+			}
+			points = append(points, *point)
+		}
+		c, err := ph.Calibrate(points...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Saved the calibration of %s, the slope is %.1f%% of the ideal probe and the offset %.3fv.\n", ph.Name, c.SlopePercent(ph.Setting), c.Offset)
+	case "ec":
+		ec, ok := sensors.Lookup(consts.EC).(*control.ECSensor)
+		if !ok {
+			return fmt.Errorf("an ec sensor is needed in the analogSensor setting")
+		}
+		var readings []control.ECReference
+		stdin := bufio.NewReader(os.Stdin)
+		for _, reference := range strings.Split(references, ",") {
+			conductivity, err := strconv.ParseFloat(strings.TrimSpace(reference), 64)
+			if err != nil {
+				return fmt.Errorf("cannot read the reference solution %q. %v", reference, err)
+			}
+			fmt.Printf("Put the probe in the %vmS/cm reference solution, wait for the reading to settle and press enter.", conductivity)
+			if _, err := stdin.ReadString('\n'); err != nil {
+				return err
+			}
+			reading, err := ec.ReadReference(conductivity, temperature)
+			if err != nil {
+				return err
+			}
+			readings = append(readings, *reading)
+		}
+		if err := ec.Calibrate(readings...); err != nil {
+			return err
+		}
+		fmt.Printf("Saved the calibration of %s, the slope is %.3fmS/cm per volt and the offset %.3fmS/cm.\n", ec.Name, ec.Calibration.Slope, ec.Calibration.Offset)
+	default:
+		return fmt.Errorf("unknown calibration %q, use waterlevel-empty, waterlevel-full, ph or ec", name)
+	}
+	return nil
+}
+
 func main() {
-	c := make(chan os.Signal, 1)
+	simulate := flag.Bool("simulate", false, "run the controller against a simulated greenhouse instead of the pi")
+	calibration := flag.String("calibrate", "", "calibrate a sensor then exit: waterlevel-empty, waterlevel-full, ph or ec")
+	buffers := flag.String("buffers", "7,4,10", "the pH of the buffers used by --calibrate ph, 1 to 3 of them")
+	references := flag.String("references", "1.413,12.88", "the conductivity (mS/cm at 25c) of the reference solutions used by --calibrate ec, 1 or 2 of them from low to high")
+	bufferTemperature := flag.Float64("bufferTemperature", 25, "the temperature (c) of the buffers or reference solutions used by --calibrate ph and ec")
+	flag.Parse()
+
 	notification := make(chan []byte, 1)
-	kill := make(chan bool, 1)
-	onTime := time.Now()
 	entry := make(chan *types.LogEntry, 1)
 	log.Println("System running")
 
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	// fan, err := control.NewOutputDevice(consts.CoolingFan)
-	// if err != nil {
-	// 	fmt.Println(err)
-	// }
-	// gl, err := control.NewGrowLight()
-	// if err != nil {
-	// 	fmt.Println(err)
-	// }
-	// go gl.TurnOnThenWait()
-
-	wl, err := control.NewWaterLevelSensor(0x48, 1)
+	var greenhouse *simulation.Greenhouse
+	if *simulate {
+		hw := control.NewSimulatedHardware()
+		control.UseHardware(hw)
+		g, err := simulation.NewGreenhouse(hw)
+		if err != nil {
+			log.Fatalf("cannot create the simulated greenhouse %v", err)
+		}
+		greenhouse = g
+		control.Go(greenhouse.Run)
+		log.Println("Running against a simulated greenhouse")
+	}
+
+	if *calibration != "" {
+		sensors, err := control.NewRegistry()
+		if err != nil {
+			log.Fatalf("got an error creating the sensors %v", err)
+		}
+		err = calibrate(*calibration, sensors, *buffers, *references, *bufferTemperature)
+		sensors.Close()
+		if err != nil {
+			log.Fatalf("the calibration was not saved %v", err)
+		}
+		return
+	}
+
+	if err := control.TrackDevices(entry); err != nil {
+		log.Println("cannot load the state of the devices", err)
+	}
+	sensors, err := control.NewRegistry()
+	if err != nil {
+		log.Fatalf("got an error creating the sensors %v", err)
+	}
+	sampler, err := control.NewSampler(sensors)
 	if err != nil {
-		fmt.Printf("got an error creating the water level sensor %v", err)
+		log.Fatalf("got an error reading the sampling setting %v", err)
 	}
 
-	// // val, err := wl.Get()
-	// // fmt.Println(val)
-	// go wl.CheckAndNotify(3.6, entry)
+	devices, err := control.NewDeviceManager()
+	if err != nil {
+		log.Fatalf("got an error creating the devices %v", err)
+	}
+	// from here on a signal or a panic leaves every device in its safe state.
+	shutdown := control.NewShutdown(devices)
+	// the loops stop when the shutdown starts, the sampler commits the readings left before the
+	// sensors are closed.
+	supervisor := control.NewSupervisor(entry)
+	ctx := shutdown.Context()
+	shutdown.AtShutdown(func() error { return supervisor.Wait(10 * time.Second) })
+	shutdown.AtShutdown(sensors.Close)
+	shutdown.Listen()
+	defer control.RecoverPanic()
 
-	temperature := control.NewTemperatureSensor()
-	// if err := temperature.Maintain(30, fan, notification); err != nil {
-	// 	fmt.Println(err)
-	// }
+	// the safety runs before any device is switched.
+	safety, err := control.NewSafety(sensors, devices)
+	if err != nil {
+		log.Fatalf("got an error reading the safety setting %v", err)
+	}
+	safety.Enforce()
+	supervisor.Go(ctx, "safety", func(ctx context.Context) error { return safety.Run(ctx, entry) })
+	supervisor.Go(ctx, "sampler", func(ctx context.Context) error { return sampler.Run(ctx, entry) })
+	devices.Run(ctx, supervisor, entry)
 
-	go func(temp *control.TemperatureSensor, waterLevel *control.WaterLevelSensor) {
-		for {
-			timer := time.NewTimer(time.Minute * 5)
-			defer timer.Stop()
-			// wait for the timer to reach its limit
-			<-timer.C
-			wlValue, err := wl.Get()
-			if err != nil {
-				fmt.Printf("%v\n", err)
-				fmt.Println("BEWARE OF THIS ERROR")
-			}
-			wlEntry := types.SensorEntry{
-				SensorType: consts.WaterLevel,
-				Time:       time.Now().Unix(),
-				Value:      wlValue,
-			}
-			e, err := json.Marshal(wlEntry)
-			if err != nil {
-				fmt.Printf("%v\n", err)
-			}
-			fmt.Println(wlEntry)
-			if err := rpc.CommitSensorData(&e); err != nil {
-				log.Println("cannot send data to the database server", err)
-			}
+	fan, err := devices.Device(consts.CoolingFan)
+	if err != nil {
+		fmt.Println(err)
+	}
+	gl, err := devices.Device(consts.GrowLight)
+	if err != nil {
+		fmt.Println(err)
+	}
+	photoperiod, err := control.NewPhotoperiod()
+	if err != nil {
+		fmt.Printf("got an error reading the photoperiod %v", err)
+	}
+	if photoperiod != nil && gl != nil {
+		supervisor.Go(ctx, "photoperiod", func(ctx context.Context) error {
+			return control.GrowLight(*gl).FollowPhotoperiod(ctx, photoperiod, entry)
+		})
+	}
 
-			t, err := temp.Prepare()
-			if err != nil {
-				log.Println("got and error from prepare method", err)
-			}
+	wl, ok := sensors.Lookup(consts.WaterLevel).(*control.WaterLevelSensor)
+	if !ok {
+		log.Fatalf("a water level sensor is needed in the analogSensor setting")
+	}
+	topUpValve, err := devices.Device(consts.TopUpValve)
+	if err != nil {
+		fmt.Println(err)
+	}
+	topUp, err := control.NewTopUp()
+	if err != nil {
+		fmt.Printf("got an error reading the top up setting %v", err)
+	}
+	if topUp == nil || topUpValve == nil {
+		supervisor.Go(ctx, "water level", func(ctx context.Context) error {
+			return wl.CheckAndNotify(ctx, wl.Reservoir.RefillBelow, entry)
+		})
+	} else {
+		supervisor.Go(ctx, "top up", func(ctx context.Context) error {
+			return wl.CheckAndTopUp(ctx, topUpValve, entry)
+		})
+	}
 
-			if err := rpc.CommitSensorData(t); err != nil {
-				log.Println("cannot send data to the database server", err)
-			}
+	rules, err := control.NewRuleEngine(sensors, devices)
+	if err != nil {
+		fmt.Printf("the rules are not running %v", err)
+	} else {
+		supervisor.Go(ctx, "rules", func(ctx context.Context) error { return rules.Run(ctx, entry) })
+	}
 
-		}
-	}(&temperature, wl)
+	if ph, ok := sensors.Lookup(consts.PH).(*control.PHSensor); ok {
+		phUp, _ := devices.Device(consts.PHUpPump)
+		phDown, _ := devices.Device(consts.PHDownPump)
+		supervisor.Go(ctx, "ph dosing", func(ctx context.Context) error {
+			return ph.Maintain(ctx, phUp, phDown, entry)
+		})
+	}
+
+	circulation, err := control.NewCirculation(devices)
+	if err != nil {
+		fmt.Printf("the circulation cycle is not running %v", err)
+	} else if circulation != nil {
+		supervisor.Go(ctx, "circulation", func(ctx context.Context) error { return circulation.Run(ctx, entry) })
+	}
+
+	humidistat, err := control.NewHumidistat(sensors, devices)
+	if err != nil {
+		fmt.Printf("the humidity control is not running %v", err)
+	} else if humidistat != nil {
+		supervisor.Go(ctx, "humidity", func(ctx context.Context) error { return humidistat.Run(ctx, entry) })
+	}
 
-	go func() {
+	heater, err := control.NewHeater(sensors, devices)
+	if err != nil {
+		fmt.Printf("the heater control is not running %v", err)
+	} else if heater != nil {
+		supervisor.Go(ctx, "heater", func(ctx context.Context) error { return heater.Run(ctx, entry) })
+	}
+
+	co2, err := control.NewCO2Enricher(sensors, devices)
+	if err != nil {
+		fmt.Printf("the co2 control is not running %v", err)
+	} else if co2 != nil {
+		supervisor.Go(ctx, "co2", func(ctx context.Context) error { return co2.Run(ctx, entry) })
+	}
+
+	if ec, ok := sensors.Lookup(consts.EC).(*control.ECSensor); ok {
+		partA, _ := devices.Device(consts.NutrientAPump)
+		partB, _ := devices.Device(consts.NutrientBPump)
+		supervisor.Go(ctx, "nutrient dosing", func(ctx context.Context) error {
+			return ec.Maintain(ctx, partA, partB, entry)
+		})
+	}
+
+	temperature, ok := sensors.Lookup(consts.Temperature).(*control.TemperatureSensor)
+	if !ok {
+		log.Fatalf("a temperature sensor is needed in the i2cSensors setting")
+	}
+	temperatureControl, err := control.NewTemperatureControl()
+	if err != nil {
+		fmt.Println(err)
+	}
+	if fan != nil && temperatureControl != nil {
+		supervisor.Go(ctx, "temperature", func(ctx context.Context) error {
+			return temperature.Maintain(ctx, temperatureControl.Setpoint, fan, notification)
+		})
+	}
+
+	if greenhouse != nil {
+		control.Go(func() {
+			for {
+				time.Sleep(time.Minute * 5)
+				log.Println("simulation:", greenhouse)
+			}
+		})
+	}
+
+	control.Go(func() {
 		for {
 			select {
 			case en := <-entry:
@@ -117,31 +317,16 @@ func main() {
 					fmt.Println(err)
 				}
 				fmt.Println("sent the notification")
+			case n := <-notification:
+				if n == nil {
+					continue
+				}
+				if err := rpc.CommitLog(&n); err != nil {
+					fmt.Println(err)
+				}
 			}
 		}
-	}()
-	go func() {
-		<-c
-		kill <- true
-	}()
-
-	<-kill
-	log.Println("cleaning up")
-	// gl.Off()
-	wl.Close()
-	msg := types.LogEntry{
-		Message: fmt.Sprintf("System terminated from the command line at %v on %v. On time %v minutes.", time.Now().Format("15:04:05"), time.Now().Format("2006-01-02"), int64(time.Now().Sub(onTime).Minutes())),
-		Success: true,
-		Time:    time.Now().Unix(),
-		Type:    "termination",
-	}
-	out, err := json.Marshal(msg)
-	if err != nil {
-		notification <- nil
-	}
-	err = rpc.CommitLog(&out)
-	if err != nil {
-		log.Println("got an error from the commit log ", err)
-	}
-	log.Println("Shutting down")
+	})
+
+	shutdown.Wait()
 }
@@ -22,13 +22,23 @@ type NotificationSVR struct{}
 type CommitSVR struct{}
 
 func (s *CommitSVR) CommitSensorData(ctx context.Context, data *controller.SensorData) (*controller.SuccessResponse, error) {
-	d := types.SensorEntry{}
-	err := json.Unmarshal(data.Data, &d)
-	if err != nil {
+	// a batch of readings is sent as a types.Sensor, a single reading as a types.SensorEntry.
+	batch := types.Sensor{}
+	if err := json.Unmarshal(data.Data, &batch); err != nil {
 		return &controller.SuccessResponse{Success: false}, err
 	}
-	err = db.AddSensorEntry(data.Key, []byte(ksuid.New().String()), d)
-	if err != nil {
+	if len(batch.Data) == 0 {
+		d := types.SensorEntry{}
+		if err := json.Unmarshal(data.Data, &d); err != nil {
+			return &controller.SuccessResponse{Success: false}, err
+		}
+		batch.Data = append(batch.Data, d)
+	}
+	keys := make([][]byte, len(batch.Data))
+	for i := range keys {
+		keys[i] = []byte(ksuid.New().String())
+	}
+	if err := db.AddSensorEntries(data.Key, keys, batch.Data); err != nil {
 		return &controller.SuccessResponse{Success: false}, err
 	}
 	return &controller.SuccessResponse{Success: true}, nil
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -88,6 +89,37 @@ func AddSensorEntry(rootBucket []byte, key []byte, value types.SensorEntry) erro
 	return nil
 }
 
+// AddSensorEntries adds the entries to the sensor bucket in one transaction, each under the key
+// at the same index.
+func AddSensorEntries(rootBucket []byte, keys [][]byte, values []types.SensorEntry) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("got %d keys for %d entries", len(keys), len(values))
+	}
+	db := initialize()
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(bytes.ToUpper(rootBucket))
+		if err != nil {
+			return fmt.Errorf("the root bucket name is too long or is empty")
+		}
+		r, err := root.CreateBucketIfNotExists(bytes.ToUpper([]byte(consts.Sensor)))
+		if err != nil {
+			return fmt.Errorf("the bucket name is too long or is empty")
+		}
+		for i, value := range values {
+			out, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			if err := r.Put(keys[i], out); err != nil {
+				return fmt.Errorf("the key is too long")
+			}
+		}
+		return nil
+	})
+}
+
 // GetSensorData returns a list of the sensor data
 // bucketName is the name of the bucket the data should be added to. To choose which type
 // of sensor data is returned set a filter.
@@ -115,7 +147,8 @@ func GetSensorData(rootBucket []byte, filter consts.BucketFilter, start int64, e
 				return err
 			}
 			RFC3339Time := time.Unix(sensorData.Time, sensorData.Time/100000000).Format(time.RFC3339)
-			if bytes.Contains(v, []byte(filter)) {
+			// the type is compared whole, humidity would match absolutehumidity otherwise.
+			if filter == consts.All || sensorData.SensorType == filter {
 				if RFC3339Time >= minTimeUnix && RFC3339Time <= maxTimeUnix {
 					sensorDataEntries = append(sensorDataEntries, sensorData)
 				}
@@ -285,9 +318,9 @@ func GetLogs(rootBucket []byte, start int64, end int64) (*[]types.LogEntry, erro
 		if logEntries == nil {
 			return fmt.Errorf("there is no entry in the root bucket")
 		}
-		log := types.LogEntry{}
-
 		if err := logEntries.ForEach(func(k, v []byte) error {
+			// a new entry each time so the device of one entry is not left on the next.
+			log := types.LogEntry{}
 			if err := json.Unmarshal(v, &log); err != nil {
 				return err
 			}
@@ -306,6 +339,46 @@ func GetLogs(rootBucket []byte, start int64, end int64) (*[]types.LogEntry, erro
 	return &logs, nil
 }
 
+// GetDeviceStates returns the last state sent by each output device of the farm.
+func GetDeviceStates(rootBucket []byte) (*[]types.DeviceState, error) {
+	db := initialize()
+	defer db.Close()
+
+	latest := map[string]types.DeviceState{}
+	if err := db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(bytes.ToUpper(rootBucket))
+		if root == nil {
+			return fmt.Errorf("the root bucket is empty")
+		}
+		logEntries := root.Bucket(bytes.ToUpper([]byte(consts.Log)))
+		if logEntries == nil {
+			return fmt.Errorf("there is no entry in the root bucket")
+		}
+		return logEntries.ForEach(func(k, v []byte) error {
+			log := types.LogEntry{}
+			if err := json.Unmarshal(v, &log); err != nil {
+				return err
+			}
+			if log.Device == nil {
+				return nil
+			}
+			if last, ok := latest[log.Device.Device]; !ok || log.Device.Since >= last.Since {
+				latest[log.Device.Device] = *log.Device
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	states := []types.DeviceState{}
+	for _, state := range latest {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Device < states[j].Device })
+	return &states, nil
+}
+
 // CreateBucket takes a name and creates a bucket if none exists
 func CreateBucket(bucketName string) error {
 	rootName := []byte(bucketName)
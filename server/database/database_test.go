@@ -40,6 +40,10 @@ var sensorData = []types.SensorEntry{
 	types.SensorEntry{SensorType: consts.PH, Time: time.Now().Unix(), Value: 7.1},
 	types.SensorEntry{SensorType: consts.PH, Time: time.Now().Unix(), Value: 7.8},
 	types.SensorEntry{SensorType: consts.Humidity, Time: time.Now().Unix(), Value: 65.1},
+	types.SensorEntry{SensorType: consts.EC, Time: time.Now().Unix(), Value: 1.8},
+	types.SensorEntry{SensorType: consts.CO2, Time: time.Now().Unix(), Value: 812},
+	types.SensorEntry{SensorType: consts.Light, Time: time.Now().Unix(), Value: 18500},
+	types.SensorEntry{SensorType: consts.WaterVolume, Time: time.Now().Unix(), Value: 57.6},
 }
 
 var sensorTT = []struct {
@@ -49,6 +53,10 @@ var sensorTT = []struct {
 	{name: consts.WaterLevel},
 	{name: consts.Temperature},
 	{name: consts.PH},
+	{name: consts.EC},
+	{name: consts.CO2},
+	{name: consts.Light},
+	{name: consts.WaterVolume},
 	{name: consts.All},
 }
 
@@ -148,8 +148,24 @@ func getSensorData(w http.ResponseWriter, r *http.Request) {
 		st = consts.Temperature
 	case "waterlevel":
 		st = consts.WaterLevel
+	case "watervolume":
+		st = consts.WaterVolume
+	case "watertemperature":
+		st = consts.WaterTemperature
 	case "ph":
 		st = consts.PH
+	case "ec":
+		st = consts.EC
+	case "co2":
+		st = consts.CO2
+	case "light":
+		st = consts.Light
+	case "vpd":
+		st = consts.VPD
+	case "dewpoint":
+		st = consts.DewPoint
+	case "absolutehumidity":
+		st = consts.AbsoluteHumidity
 	case "all":
 		st = consts.All
 	default:
@@ -197,6 +213,26 @@ func getLogs(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// getDevices returns the last state of every output device. The runtime of a device that is on
+// includes the time since it was turned on.
+func getDevices(w http.ResponseWriter, r *http.Request) {
+	claims := getClaims(w, r)
+	key := claims["key"].(string)
+	states, err := db.GetDeviceStates([]byte(key))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Errorf("Something went wrong getting the data requested"))
+		return
+	}
+	now := time.Now().Unix()
+	for i, state := range *states {
+		if state.On && now > state.Since {
+			(*states)[i].Runtime += now - state.Since
+		}
+	}
+	sendResponse(w, states)
+	return
+}
+
 // changeSettings edits the config file of the system
 func changeSettings(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("trying to change settings")
@@ -424,8 +460,24 @@ func filterSensorEntry(e types.SensorEntry, w *types.Week, weekStart time.Time,
 		switch e.SensorType {
 		case consts.Temperature:
 			w.Data.Temperature.Values = append(w.Data.Temperature.Values, e.Value)
+		case consts.Humidity:
+			w.Data.Humidity.Values = append(w.Data.Humidity.Values, e.Value)
+		case consts.VPD:
+			w.Data.VPD.Values = append(w.Data.VPD.Values, e.Value)
+		case consts.DewPoint:
+			w.Data.DewPoint.Values = append(w.Data.DewPoint.Values, e.Value)
+		case consts.AbsoluteHumidity:
+			w.Data.AbsoluteHumidity.Values = append(w.Data.AbsoluteHumidity.Values, e.Value)
 		case consts.WaterLevel:
 			w.Data.WaterLevel.Values = append(w.Data.WaterLevel.Values, e.Value)
+		case consts.WaterVolume:
+			w.Data.WaterVolume.Values = append(w.Data.WaterVolume.Values, e.Value)
+		case consts.EC:
+			w.Data.EC.Values = append(w.Data.EC.Values, e.Value)
+		case consts.CO2:
+			w.Data.CO2.Values = append(w.Data.CO2.Values, e.Value)
+		case consts.Light:
+			w.Data.Light.Values = append(w.Data.Light.Values, e.Value)
 		}
 	}
 }
@@ -457,6 +509,7 @@ func server() *http.Server {
 	router.Handle("/api/sensor/", isProtected(getSensorData)).Methods("GET")
 	router.Handle("/userinfo", isProtected(userinfo)).Methods("GET")
 	router.Handle("/api/logs/", isProtected(getLogs)).Methods("GET")
+	router.Handle("/api/devices", isProtected(getDevices)).Methods("GET")
 	router.Handle("/api/settings", isProtected(changeSettings)).Methods("POST")
 	router.Handle("/api/farmdetails", isProtected(addFarmDetails)).Methods("POST")
 	router.Handle("/api/farmdetails", isProtected(getFarmDetails)).Methods("GET")
@@ -70,6 +70,15 @@ var testData = []struct {
 			reqType:  "get",
 		},
 	},
+	{
+		userAuth: &auth{username: "isuspisus1@gmail.com", password: "qwerty", response: http.StatusOK},
+		endpointInformation: endpoint{
+			endpoint: fmt.Sprintf("api/sensor/?sensortype=ec&starttime=%d&endtime=%d", convertDate("2019-03-13T00:00:00+00:00"), convertDate("2019-03-14T00:00:00+00:00")),
+			name:     "sensor",
+			response: http.StatusOK,
+			reqType:  "get",
+		},
+	},
 	{
 		userAuth: &auth{username: "isuspisus1@gmail.com", password: "qwerty", response: http.StatusOK},
 		endpointInformation: endpoint{
@@ -0,0 +1,333 @@
+package simulation
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/only1isus/majorProj/config"
+	"github.com/only1isus/majorProj/consts"
+	"github.com/only1isus/majorProj/control"
+)
+
+// Setting is the simulation section of the config file. Rates are per simulated minute.
+type Setting struct {
+	Every                 int64   `yaml:"every"` // seconds between steps.
+	Speed                 float64 `yaml:"speed"` // simulated minutes per real minute.
+	AmbientTemperature    float64 `yaml:"ambientTemperature"`
+	AmbientHumidity       float64 `yaml:"ambientHumidity"`
+	HeatGain              float64 `yaml:"heatGain"`    // degrees above ambient the room settles at with the fan off.
+	LightHeat             float64 `yaml:"lightHeat"`   // extra degrees while the grow light is on.
+	HeatingRate           float64 `yaml:"heatingRate"` // fraction of the gap to the settle temperature closed every minute.
+	FanCoolingRate        float64 `yaml:"fanCoolingRate"`
+	HeaterGain            float64 `yaml:"heaterGain"`       // degrees added every minute the heater runs.
+	Transpiration         float64 `yaml:"transpiration"`    // humidity (%) added every minute.
+	HumidifierRate        float64 `yaml:"humidifierRate"`   // humidity (%) added every minute the humidifier runs.
+	DehumidifierRate      float64 `yaml:"dehumidifierRate"` // humidity (%) taken out every minute the dehumidifier runs.
+	DrainRate             float64 `yaml:"drainRate"`        // water level (%) lost every minute.
+	FillRate              float64 `yaml:"fillRate"`         // water level (%) added every minute the top up valve is open.
+	WaterWarmingRate      float64 `yaml:"waterWarmingRate"` // fraction of the gap to the air temperature the water closes every minute.
+	PHDrift               float64 `yaml:"phDrift"`          // pH change every hour.
+	PHNoise               float64 `yaml:"phNoise"`          // standard deviation of the pH random walk over a minute.
+	PHDoseRate            float64 `yaml:"phDoseRate"`       // pH change for every minute a ph pump runs.
+	ECDrift               float64 `yaml:"ecDrift"`          // conductivity (mS/cm) change every hour.
+	NutrientDoseRate      float64 `yaml:"nutrientDoseRate"` // conductivity (mS/cm) added for every minute both nutrient pumps run.
+	AmbientCO2            float64 `yaml:"ambientCO2"`       // ppm
+	CO2Uptake             float64 `yaml:"co2Uptake"`        // ppm taken in by the plants every minute the grow light is on.
+	CO2InjectionRate      float64 `yaml:"co2InjectionRate"` // ppm added every minute the co2 valve is open.
+	AirLeakRate           float64 `yaml:"airLeakRate"`      // fraction of the air replaced every minute with the fan off.
+	GrowLightLux          float64 `yaml:"growLightLux"`
+	StartTemperature      float64 `yaml:"startTemperature"`
+	StartHumidity         float64 `yaml:"startHumidity"`
+	StartWaterLevel       float64 `yaml:"startWaterLevel"` // %
+	StartWaterTemperature float64 `yaml:"startWaterTemperature"`
+	StartPH               float64 `yaml:"startPH"`
+	StartEC               float64 `yaml:"startEC"`
+	WaterLevelFull        float64 `yaml:"waterLevelFull"` // adc reading of a full reservoir.
+	ADC                   Address `yaml:"adc"`            // the ADS1115 the analog sensors are connected to.
+}
+
+// Address is the location of a device on the I2C bus.
+type Address struct {
+	Address int `yaml:"address"`
+	Bus     int `yaml:"bus"`
+}
+
+type simulationConfig struct {
+	Simulation Setting `yaml:"simulation"`
+}
+
+// defaultSetting is used for every value left out of the config file.
+var defaultSetting = Setting{
+	Every:                 5,
+	Speed:                 1,
+	AmbientTemperature:    24,
+	AmbientHumidity:       50,
+	HeatGain:              10,
+	LightHeat:             3,
+	HeatingRate:           0.05,
+	FanCoolingRate:        0.25,
+	HeaterGain:            0.5,
+	Transpiration:         0.2,
+	HumidifierRate:        1,
+	DehumidifierRate:      1,
+	DrainRate:             0.05,
+	FillRate:              10,
+	WaterWarmingRate:      0.01,
+	PHDrift:               0.05,
+	PHNoise:               0.01,
+	PHDoseRate:            3,
+	ECDrift:               -0.01,
+	NutrientDoseRate:      0.5,
+	AmbientCO2:            420,
+	CO2Uptake:             15,
+	CO2InjectionRate:      100,
+	AirLeakRate:           0.02,
+	GrowLightLux:          20000,
+	StartTemperature:      26,
+	StartHumidity:         60,
+	StartWaterLevel:       90,
+	StartWaterTemperature: 22,
+	StartPH:               6.5,
+	StartEC:               1.8,
+	WaterLevelFull:        4,
+	ADC:                   Address{Address: 0x48, Bus: 1},
+}
+
+// pH probe response at 25c used to turn the simulated pH into a voltage. It matches the default
+// calibration of control.PHSensor.
+const (
+	phProbeSlope  = (1.993 - 1.476) / (4.0 - 7.0)
+	phProbeOffset = 1.476 // volts at pH 7.
+)
+
+// Greenhouse is a physics-lite model of the grow environment. It reads the actuators from the
+// simulated pins and writes the state of the environment to the simulated sensors.
+type Greenhouse struct {
+	sync.Mutex
+	Setting          Setting
+	Temperature      float64
+	Humidity         float64
+	WaterLevel       float64 // %
+	WaterTemperature float64
+	PH               float64
+	EC               float64 // mS/cm at 25c
+	CO2              float64 // ppm
+	Light            float64 // lux
+
+	hw           *control.SimulatedHardware
+	climate      *control.SimulatedSHT3x
+	co2          *control.SimulatedSCD30
+	light        *control.SimulatedBH1750
+	fan          *control.OutputDevice
+	growLight    *control.OutputDevice
+	phUp         *control.OutputDevice
+	phDown       *control.OutputDevice
+	topUp        *control.OutputDevice
+	nutrientA    *control.OutputDevice
+	nutrientB    *control.OutputDevice
+	heater       *control.OutputDevice
+	humidifier   *control.OutputDevice
+	dehumidifier *control.OutputDevice
+	co2Valve     *control.OutputDevice
+	waterLevel   *control.ADCSensor
+	ph           *control.ADCSensor
+	ec           *control.ADCSensor
+	water        *control.ADCSensor
+	thermistor   *control.Thermistor
+}
+
+// NewSetting reads the simulation section of the config file. Missing values are taken
+// from the defaults.
+func NewSetting() (*Setting, error) {
+	setting := simulationConfig{Simulation: defaultSetting}
+	configFile, err := config.ReadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(configFile, &setting); err != nil {
+		return nil, err
+	}
+	return &setting.Simulation, nil
+}
+
+// NewGreenhouse attaches a greenhouse to the simulated hardware. The devices and sensors it
+// drives are looked up in the config file.
+func NewGreenhouse(hw *control.SimulatedHardware) (*Greenhouse, error) {
+	setting, err := NewSetting()
+	if err != nil {
+		return nil, err
+	}
+	g := &Greenhouse{
+		Setting:          *setting,
+		Temperature:      setting.StartTemperature,
+		Humidity:         setting.StartHumidity,
+		WaterLevel:       setting.StartWaterLevel,
+		WaterTemperature: setting.StartWaterTemperature,
+		PH:               setting.StartPH,
+		EC:               setting.StartEC,
+		CO2:              setting.AmbientCO2,
+		hw:               hw,
+	}
+
+	if g.fan, err = control.NewOutputDevice(consts.CoolingFan); err != nil {
+		return nil, err
+	}
+	if g.growLight, err = control.NewOutputDevice(consts.GrowLight); err != nil {
+		return nil, err
+	}
+	if g.phUp, err = control.NewOutputDevice(consts.PHUpPump); err != nil {
+		return nil, err
+	}
+	if g.phDown, err = control.NewOutputDevice(consts.PHDownPump); err != nil {
+		return nil, err
+	}
+	// the top up valve is optional.
+	if g.topUp, err = control.NewOutputDevice(consts.TopUpValve); err != nil {
+		log.Printf("simulating without a top up valve. %v", err)
+	}
+	// so are the nutrient pumps.
+	if g.nutrientA, err = control.NewOutputDevice(consts.NutrientAPump); err != nil {
+		log.Printf("simulating without nutrient dosing. %v", err)
+	} else if g.nutrientB, err = control.NewOutputDevice(consts.NutrientBPump); err != nil {
+		log.Printf("simulating without nutrient dosing. %v", err)
+	}
+	// and the heater, the humidifier, the dehumidifier and the co2 valve.
+	if g.heater, err = control.NewOutputDevice(consts.Heater); err != nil {
+		log.Printf("simulating without a heater. %v", err)
+	}
+	if g.humidifier, err = control.NewOutputDevice(consts.Humidifier); err != nil {
+		log.Printf("simulating without a humidifier. %v", err)
+	}
+	if g.dehumidifier, err = control.NewOutputDevice(consts.Dehumidifier); err != nil {
+		log.Printf("simulating without a dehumidifier. %v", err)
+	}
+	if g.co2Valve, err = control.NewOutputDevice(consts.CO2Valve); err != nil {
+		log.Printf("simulating without a co2 valve. %v", err)
+	}
+	climateSensor, err := control.NewTemperatureSensor()
+	if err != nil {
+		return nil, err
+	}
+	g.climate = control.NewSimulatedSHT3x(g.Temperature, g.Humidity)
+	hw.Attach(climateSensor.Address, climateSensor.Bus, g.climate)
+
+	// the co2 and light sensors are optional.
+	if co2Sensor, err := control.NewI2CSensor(consts.SCD30); err == nil {
+		g.co2 = control.NewSimulatedSCD30(g.CO2)
+		hw.Attach(co2Sensor.Address, co2Sensor.Bus, g.co2)
+	}
+	if lightSensor, err := control.NewI2CSensor(consts.BH1750); err == nil {
+		g.light = control.NewSimulatedBH1750(g.Light)
+		hw.Attach(lightSensor.Address, lightSensor.Bus, g.light)
+	}
+
+	if g.waterLevel, err = control.NewAnalogSensor(consts.WaterLevelSensor); err != nil {
+		return nil, err
+	}
+	// the ph and ec sensors are optional.
+	if g.ph, err = control.NewAnalogSensor(consts.PHSensor); err != nil {
+		log.Printf("simulating without a ph sensor. %v", err)
+	}
+	if g.ec, err = control.NewAnalogSensor(consts.ECSensor); err != nil {
+		log.Printf("simulating without an ec sensor. %v", err)
+	}
+	if g.water, err = control.NewAnalogSensor(consts.WaterTemperatureSensor); err != nil {
+		log.Printf("simulating without a water temperature sensor. %v", err)
+	} else if g.thermistor, err = control.NewThermistor(); err != nil {
+		return nil, err
+	}
+	g.publish()
+	return g, nil
+}
+
+// Run steps the simulation every Setting.Every seconds.
+func (g *Greenhouse) Run() {
+	interval := time.Second * time.Duration(g.Setting.Every)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		g.Step(time.Duration(float64(interval) * g.Setting.Speed))
+	}
+}
+
+// Step advances the environment by d of simulated time and updates the sensors.
+func (g *Greenhouse) Step(d time.Duration) {
+	g.Lock()
+	defer g.Unlock()
+
+	minutes := d.Minutes()
+	s := g.Setting
+	fanDuty := g.duty(g.fan)
+	lightDuty := g.duty(g.growLight)
+	settleTemperature := s.AmbientTemperature + s.HeatGain
+	if lightDuty > 0 {
+		settleTemperature += s.LightHeat
+	}
+
+	g.Temperature += (s.HeatingRate*(settleTemperature-g.Temperature) + s.HeaterGain*g.duty(g.heater) - s.FanCoolingRate*fanDuty*(g.Temperature-s.AmbientTemperature)) * minutes
+	g.Humidity += (s.Transpiration + s.HumidifierRate*g.duty(g.humidifier) - s.DehumidifierRate*g.duty(g.dehumidifier) - s.FanCoolingRate*fanDuty*(g.Humidity-s.AmbientHumidity)) * minutes
+	g.Humidity = math.Max(0, math.Min(100, g.Humidity))
+	g.WaterLevel = math.Max(0, math.Min(100, g.WaterLevel+(s.FillRate*g.duty(g.topUp)-s.DrainRate)*minutes))
+	g.WaterTemperature += s.WaterWarmingRate * (g.Temperature - g.WaterTemperature) * minutes
+	// the noise is a random walk, its spread grows with the square root of the time stepped.
+	g.PH += s.PHDrift*minutes/60 + rand.NormFloat64()*s.PHNoise*math.Sqrt(minutes)
+	g.PH += (g.duty(g.phUp) - g.duty(g.phDown)) * s.PHDoseRate * minutes
+	g.PH = math.Max(0, math.Min(14, g.PH))
+	g.EC = math.Max(0, g.EC+s.ECDrift*minutes/60+(g.duty(g.nutrientA)+g.duty(g.nutrientB))/2*s.NutrientDoseRate*minutes)
+	// the plants take in co2 under the light, the fan brings in outside air and the valve adds co2.
+	g.CO2 += (s.FanCoolingRate*fanDuty*(s.AmbientCO2-g.CO2) + s.AirLeakRate*(s.AmbientCO2-g.CO2) - s.CO2Uptake*lightDuty + s.CO2InjectionRate*g.duty(g.co2Valve)) * minutes
+	g.CO2 = math.Max(0, g.CO2)
+	g.Light = s.GrowLightLux * lightDuty
+
+	g.publish()
+}
+
+// duty returns the duty cycle the device is driven at, 0 if the device is not configured.
+func (g *Greenhouse) duty(device *control.OutputDevice) float64 {
+	if device == nil {
+		return 0
+	}
+	if device.Pins.EN == 0 {
+		// switched by a relay.
+		if g.hw.PinState(device.Pin).High {
+			return 1
+		}
+		return 0
+	}
+	return g.hw.PinState(device.Pins.EN).DutyCycle
+}
+
+// publish writes the state of the environment to the simulated sensors.
+func (g *Greenhouse) publish() {
+	g.climate.Set(g.Temperature, g.Humidity)
+	if g.co2 != nil {
+		g.co2.Set(g.CO2)
+	}
+	if g.light != nil {
+		g.light.Set(g.Light)
+	}
+	adc := g.hw.ADC(g.Setting.ADC.Address, g.Setting.ADC.Bus)
+	adc.Set(g.waterLevel.AnalogPin, g.WaterLevel/100*g.Setting.WaterLevelFull)
+	if g.ph != nil {
+		// the slope of the probe grows with the absolute temperature of the water.
+		adc.Set(g.ph.AnalogPin, phProbeOffset+phProbeSlope*(g.PH-7)*(g.WaterTemperature+273.15)/298.15)
+	}
+	if g.ec != nil {
+		// read by a probe with a slope of 1 mS/cm per volt, the conductivity grows by 2% a degree.
+		adc.Set(g.ec.AnalogPin, g.EC*(1+0.02*(g.WaterTemperature-25)))
+	}
+	if g.water != nil {
+		adc.Set(g.water.AnalogPin, g.thermistor.Voltage(g.WaterTemperature))
+	}
+}
+
+func (g *Greenhouse) String() string {
+	g.Lock()
+	defer g.Unlock()
+	return fmt.Sprintf("temperature %.1fc, humidity %.1f%%, water level %.1f%%, water temperature %.1fc, ph %.2f, ec %.2f, co2 %.0fppm, light %.0flux", g.Temperature, g.Humidity, g.WaterLevel, g.WaterTemperature, g.PH, g.EC, g.CO2, g.Light)
+}
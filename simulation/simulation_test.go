@@ -0,0 +1,105 @@
+package simulation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/only1isus/majorProj/control"
+)
+
+func newTestGreenhouse(hw *control.SimulatedHardware) *Greenhouse {
+	return &Greenhouse{
+		Setting:     defaultSetting,
+		Temperature: 30,
+		Humidity:    60,
+		WaterLevel:  50,
+		PH:          6.5,
+		hw:          hw,
+		climate:     control.NewSimulatedSHT3x(30, 60),
+		fan:         &control.OutputDevice{Pins: control.DriverPins{EN: 21, IN1: 20, IN2: 16}, Rate: 1},
+		waterLevel:  &control.ADCSensor{AnalogPin: 0},
+	}
+}
+
+func TestFanCoolsTheGreenhouse(t *testing.T) {
+	hw := control.NewSimulatedHardware()
+	control.UseHardware(hw)
+	defer control.UseHardware(nil)
+
+	idle := newTestGreenhouse(hw)
+	idle.Step(10 * time.Minute)
+
+	cooled := newTestGreenhouse(hw)
+	if err := cooled.fan.On(); err != nil {
+		t.Fatal(err)
+	}
+	cooled.Step(10 * time.Minute)
+
+	if idle.Temperature <= 30 {
+		t.Errorf("expected the temperature to rise with the fan off, got %v", idle.Temperature)
+	}
+	if cooled.Temperature >= 30 {
+		t.Errorf("expected the temperature to fall with the fan on, got %v", cooled.Temperature)
+	}
+	if cooled.WaterLevel >= 50 {
+		t.Errorf("expected the water level to drain, got %v", cooled.WaterLevel)
+	}
+	if v, _ := hw.ADC(0x48, 1).Read(0); v != cooled.WaterLevel/100*defaultSetting.WaterLevelFull {
+		t.Errorf("expected the water level to be published to the adc, got %v", v)
+	}
+}
+
+func TestHumidifierAndDehumidifier(t *testing.T) {
+	hw := control.NewSimulatedHardware()
+	control.UseHardware(hw)
+	defer control.UseHardware(nil)
+
+	idle := newTestGreenhouse(hw)
+	idle.Step(10 * time.Minute)
+
+	humidified := newTestGreenhouse(hw)
+	humidified.humidifier = &control.OutputDevice{Pin: 24}
+	if err := humidified.humidifier.On(); err != nil {
+		t.Fatal(err)
+	}
+	humidified.Step(10 * time.Minute)
+	if err := humidified.humidifier.Off(); err != nil {
+		t.Fatal(err)
+	}
+
+	dried := newTestGreenhouse(hw)
+	dried.dehumidifier = &control.OutputDevice{Pin: 26}
+	if err := dried.dehumidifier.On(); err != nil {
+		t.Fatal(err)
+	}
+	dried.Step(10 * time.Minute)
+
+	if humidified.Humidity <= idle.Humidity {
+		t.Errorf("expected the humidifier to add humidity, got %v with it and %v without", humidified.Humidity, idle.Humidity)
+	}
+	if dried.Humidity >= 60 {
+		t.Errorf("expected the dehumidifier to take the humidity down, got %v", dried.Humidity)
+	}
+}
+
+func TestHeaterWarmsTheGreenhouse(t *testing.T) {
+	hw := control.NewSimulatedHardware()
+	control.UseHardware(hw)
+	defer control.UseHardware(nil)
+
+	idle := newTestGreenhouse(hw)
+	idle.Temperature = 15
+	idle.Step(10 * time.Minute)
+
+	heated := newTestGreenhouse(hw)
+	heated.Temperature = 15
+	heated.heater = &control.OutputDevice{Pin: 23}
+	if err := heated.heater.On(); err != nil {
+		t.Fatal(err)
+	}
+	heated.Step(10 * time.Minute)
+
+	if heated.Temperature-idle.Temperature < 10*defaultSetting.HeaterGain-0.01 {
+		t.Errorf("expected the heater to add %vc in 10 minutes, got %v with it and %v without", 10*defaultSetting.HeaterGain, heated.Temperature, idle.Temperature)
+	}
+}
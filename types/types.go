@@ -19,9 +19,33 @@ type Week struct {
 		Temperature struct {
 			Values []float64 `json:"values"`
 		} `json:"temperature"`
+		Humidity struct {
+			Values []float64 `json:"values"`
+		} `json:"humidity"`
+		VPD struct {
+			Values []float64 `json:"values"`
+		} `json:"vpd"`
+		DewPoint struct {
+			Values []float64 `json:"values"`
+		} `json:"dewpoint"`
+		AbsoluteHumidity struct {
+			Values []float64 `json:"values"`
+		} `json:"absolutehumidity"`
 		WaterLevel struct {
 			Values []float64 `json:"values"`
 		} `json:"waterlevel"`
+		WaterVolume struct {
+			Values []float64 `json:"values"`
+		} `json:"watervolume"`
+		EC struct {
+			Values []float64 `json:"values"`
+		} `json:"ec"`
+		CO2 struct {
+			Values []float64 `json:"values"`
+		} `json:"co2"`
+		Light struct {
+			Values []float64 `json:"values"`
+		} `json:"light"`
 	}
 }
 
@@ -32,10 +56,22 @@ type Log struct {
 
 // LogEntry ...
 type LogEntry struct {
-	Type    string `json:"type"`
-	Time    int64  `json:"time"`
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Type    string       `json:"type"`
+	Time    int64        `json:"time"`
+	Success bool         `json:"success"`
+	Message string       `json:"message"`
+	Device  *DeviceState `json:"device,omitempty"` // set on the entries of a device switching.
+}
+
+// DeviceState is the state of an output device after it was switched.
+type DeviceState struct {
+	Device  string  `json:"device"`
+	On      bool    `json:"on"`
+	Rate    float64 `json:"rate"`
+	Reason  string  `json:"reason"`  // schedule, rule, control, manual or safety.
+	Since   int64   `json:"since"`   // time the device was last turned on or off.
+	Runtime int64   `json:"runtime"` // seconds the device was on in total, up to since.
+	Cycles  int64   `json:"cycles"`  // times the device was turned on.
 }
 
 // DatabaseConnection ...